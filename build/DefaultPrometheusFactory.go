@@ -12,6 +12,13 @@ import (
 // See: Factory
 // See: PrometheusCounters
 // See: PrometheusMetricsService
+// See: PrometheusMetricsServer
+// See: PrometheusDiscoveryService
+// See: PrometheusPushGatewayService
+// See: GoRuntimeCollector
+// See: ProcessCollector
+// See: PrometheusScrapeCollector
+// See: HttpMetricsInterceptor
 type DefaultPrometheusFactory struct {
 	cbuild.Factory
 }
@@ -23,8 +30,22 @@ func NewDefaultPrometheusFactory() *DefaultPrometheusFactory {
 
 	prometheusCountersDescriptor := cref.NewDescriptor("pip-services", "counters", "prometheus", "*", "1.0")
 	prometheusMetricsServiceDescriptor := cref.NewDescriptor("pip-services", "metrics-service", "prometheus", "*", "1.0")
+	prometheusMetricsServerDescriptor := cref.NewDescriptor("pip-services", "metrics-server", "prometheus", "*", "1.0")
+	prometheusDiscoveryServiceDescriptor := cref.NewDescriptor("pip-services", "discovery-service", "prometheus", "*", "1.0")
+	runtimeCollectorDescriptor := cref.NewDescriptor("pip-services", "metrics-collector", "runtime", "*", "1.0")
+	processCollectorDescriptor := cref.NewDescriptor("pip-services", "metrics-collector", "process", "*", "1.0")
+	scrapeCollectorDescriptor := cref.NewDescriptor("pip-services", "metrics-relay", "scrape", "*", "1.0")
+	pushGatewayServiceDescriptor := cref.NewDescriptor("pip-services", "metrics-relay", "pushgateway", "*", "1.0")
+	httpMetricsInterceptorDescriptor := cref.NewDescriptor("pip-services", "metrics-interceptor", "http", "*", "1.0")
 
 	c.RegisterType(prometheusCountersDescriptor, pcount.NewPrometheusCounters)
 	c.RegisterType(prometheusMetricsServiceDescriptor, pservices.NewPrometheusMetricsService)
+	c.RegisterType(prometheusMetricsServerDescriptor, pservices.NewPrometheusMetricsServer)
+	c.RegisterType(prometheusDiscoveryServiceDescriptor, pservices.NewPrometheusDiscoveryService)
+	c.RegisterType(runtimeCollectorDescriptor, pcount.NewGoRuntimeCollector)
+	c.RegisterType(processCollectorDescriptor, pcount.NewProcessCollector)
+	c.RegisterType(scrapeCollectorDescriptor, pcount.NewPrometheusScrapeCollector)
+	c.RegisterType(pushGatewayServiceDescriptor, pservices.NewPrometheusPushGatewayService)
+	c.RegisterType(httpMetricsInterceptorDescriptor, pservices.NewHttpMetricsInterceptor)
 	return &c
 }
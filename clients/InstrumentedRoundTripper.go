@@ -0,0 +1,187 @@
+package clients
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+)
+
+// Names of metrics recorded by InstrumentedRoundTripper
+const (
+	clientInFlightMetric = "http_client_requests_in_flight"
+	clientDurationMetric = "http_client_request_duration_seconds"
+	clientDnsMetric      = "http_client_dns_duration_seconds"
+	clientConnectMetric  = "http_client_connect_duration_seconds"
+	clientTlsMetric      = "http_client_tls_duration_seconds"
+)
+
+/*
+InstrumentedRoundTripper is an http.RoundTripper that wraps another one and records metrics
+about outgoing requests into PrometheusCounters registry:
+
+  - http_client_requests_in_flight{host}                        number of requests in progress
+  - http_client_request_duration_seconds{host,method,status}    histogram of request durations,
+    where status is a status class like 2xx or "error" when no response was received
+  - http_client_dns_duration_seconds{host}                      histogram of DNS lookup durations
+  - http_client_connect_duration_seconds{host}                  histogram of TCP connect durations
+  - http_client_tls_duration_seconds{host}                      histogram of TLS handshake durations
+
+DNS, connect and TLS timings are measured with httptrace and recorded only when a new connection is established.
+
+Example:
+
+    client := rpcclients.NewRestClient()
+    ...
+    client.Open("123")
+    InstrumentClient(client.Client, counters)
+*/
+type InstrumentedRoundTripper struct {
+	next     http.RoundTripper
+	counters *pcount.PrometheusCounters
+	buckets  []float64
+}
+
+// NewInstrumentedRoundTripper creates a new instance of the round tripper.
+//   - counters    PrometheusCounters to record metrics.
+//   - next        a wrapped round tripper, http.DefaultTransport when nil.
+// Returns *InstrumentedRoundTripper
+// pointer on new instance
+func NewInstrumentedRoundTripper(counters *pcount.PrometheusCounters, next http.RoundTripper) *InstrumentedRoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &InstrumentedRoundTripper{
+		next:     next,
+		counters: counters,
+	}
+}
+
+// InstrumentClient wraps transport of the HTTP client with InstrumentedRoundTripper.
+//   - client      an HTTP client to instrument.
+//   - counters    PrometheusCounters to record metrics.
+func InstrumentClient(client *http.Client, counters *pcount.PrometheusCounters) {
+	if client == nil {
+		return
+	}
+	if _, ok := client.Transport.(*InstrumentedRoundTripper); ok {
+		return
+	}
+	client.Transport = NewInstrumentedRoundTripper(counters, client.Transport)
+}
+
+// SetBuckets method sets upper bounds of histogram buckets in seconds.
+// PrometheusDefaultBuckets are used by default.
+//   - buckets    upper bounds of buckets.
+func (c *InstrumentedRoundTripper) SetBuckets(buckets []float64) {
+	c.buckets = buckets
+}
+
+// RoundTrip method executes a single HTTP transaction and records its metrics.
+//   - req    an HTTP request.
+// Returns *http.Response, error
+// the HTTP response or error if request failed.
+func (c *InstrumentedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.counters == nil {
+		return c.next.RoundTrip(req)
+	}
+
+	registry := c.counters.Registry()
+	host := req.URL.Host
+	hostLabels := map[string]string{"host": host}
+
+	registry.AddGauge(clientInFlightMetric, "Number of outgoing HTTP requests in progress", hostLabels, 1)
+	defer registry.AddGauge(clientInFlightMetric, "Number of outgoing HTTP requests in progress", hostLabels, -1)
+
+	trace := newClientTrace(c, registry, hostLabels)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.ClientTrace()))
+
+	start := time.Now()
+	res, err := c.next.RoundTrip(req)
+	duration := time.Since(start).Seconds()
+
+	status := "error"
+	if err == nil && res != nil {
+		status = strconv.Itoa(res.StatusCode/100) + "xx"
+	}
+	registry.ObserveHistogram(clientDurationMetric, "Duration of outgoing HTTP requests in seconds", c.buckets,
+		map[string]string{"host": host, "method": strings.ToUpper(req.Method), "status": status}, duration)
+
+	return res, err
+}
+
+// Measures connection phases of a single request.
+// Trace callbacks may be called from different goroutines, so the state is protected by a lock.
+type clientTrace struct {
+	owner      *InstrumentedRoundTripper
+	registry   *pcount.PrometheusMetricsRegistry
+	labels     map[string]string
+	lock       sync.Mutex
+	dnsStart   time.Time
+	connStarts map[string]time.Time
+	tlsStart   time.Time
+}
+
+func newClientTrace(owner *InstrumentedRoundTripper, registry *pcount.PrometheusMetricsRegistry,
+	labels map[string]string) *clientTrace {
+	return &clientTrace{
+		owner:      owner,
+		registry:   registry,
+		labels:     labels,
+		connStarts: make(map[string]time.Time),
+	}
+}
+
+func (c *clientTrace) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			c.lock.Lock()
+			c.dnsStart = time.Now()
+			c.lock.Unlock()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			c.lock.Lock()
+			start := c.dnsStart
+			c.lock.Unlock()
+			if info.Err == nil && !start.IsZero() {
+				c.observe(clientDnsMetric, "Duration of DNS lookups in seconds", start)
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			c.lock.Lock()
+			c.connStarts[network+":"+addr] = time.Now()
+			c.lock.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			c.lock.Lock()
+			start, ok := c.connStarts[network+":"+addr]
+			delete(c.connStarts, network+":"+addr)
+			c.lock.Unlock()
+			if err == nil && ok {
+				c.observe(clientConnectMetric, "Duration of TCP connects in seconds", start)
+			}
+		},
+		TLSHandshakeStart: func() {
+			c.lock.Lock()
+			c.tlsStart = time.Now()
+			c.lock.Unlock()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			c.lock.Lock()
+			start := c.tlsStart
+			c.lock.Unlock()
+			if err == nil && !start.IsZero() {
+				c.observe(clientTlsMetric, "Duration of TLS handshakes in seconds", start)
+			}
+		},
+	}
+}
+
+func (c *clientTrace) observe(name string, help string, start time.Time) {
+	c.registry.ObserveHistogram(name, help, c.owner.buckets, c.labels, time.Since(start).Seconds())
+}
@@ -0,0 +1,232 @@
+package count
+
+import (
+	"math"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+)
+
+/*
+GoRuntimeCollector is a collector that exposes standard Go runtime metrics:
+number of goroutines and threads, GC pause summary, heap and stack memory statistics
+and histograms from runtime/metrics package.
+
+Metric names follow the ones used by other Go exporters (go_goroutines, go_gc_duration_seconds,
+go_memstats_heap_alloc_bytes, ...). Histograms from runtime/metrics are named after the metric,
+for instance /sched/latencies:seconds becomes go_sched_latencies_seconds. Their buckets are reduced
+to at most max_buckets and their sums are estimated from the buckets, because runtime doesn't provide them.
+
+Configuration parameters:
+
+  - options:
+    - histograms:            expose histograms from runtime/metrics (default: true)
+    - max_buckets:           maximum number of buckets in runtime/metrics histograms (default: 32)
+
+Example:
+
+    collector := NewGoRuntimeCollector()
+
+    references := cref.NewReferencesFromTuples(
+        cref.NewDescriptor("pip-services", "metrics-collector", "runtime", "default", "1.0"), collector,
+    )
+*/
+type GoRuntimeCollector struct {
+	histograms bool
+	maxBuckets int
+}
+
+var runtimeMetricNameRegex = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// NewGoRuntimeCollector creates a new instance of the collector.
+// Returns *GoRuntimeCollector
+// pointer on new instance
+func NewGoRuntimeCollector() *GoRuntimeCollector {
+	return &GoRuntimeCollector{
+		histograms: true,
+		maxBuckets: 32,
+	}
+}
+
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+// - config   *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *GoRuntimeCollector) Configure(config *cconf.ConfigParams) {
+	c.histograms = config.GetAsBooleanWithDefault("options.histograms", c.histograms)
+	c.maxBuckets = config.GetAsIntegerWithDefault("options.max_buckets", c.maxBuckets)
+}
+
+// Collect method sends current Go runtime metrics to the channel.
+//   - ch    a channel to send collected metric families.
+func (c *GoRuntimeCollector) Collect(ch chan<- *PrometheusMetricFamily) {
+	ch <- c.gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+
+	threads, _ := runtime.ThreadCreateProfile(nil)
+	ch <- c.gauge("go_threads", "Number of OS threads created.", float64(threads))
+
+	info := NewPrometheusMetricFamily("go_info", PrometheusGauge, "Information about the Go environment.")
+	info.AddSample("go_info", map[string]string{"version": runtime.Version()}, 1)
+	ch <- info
+
+	ch <- c.collectGcStats()
+
+	for _, family := range c.collectMemStats() {
+		ch <- family
+	}
+
+	if c.histograms {
+		for _, family := range c.collectHistograms() {
+			ch <- family
+		}
+	}
+}
+
+func (c *GoRuntimeCollector) gauge(name string, help string, value float64) *PrometheusMetricFamily {
+	family := NewPrometheusMetricFamily(name, PrometheusGauge, help)
+	family.AddSample(name, nil, value)
+	return family
+}
+
+func (c *GoRuntimeCollector) counter(name string, help string, value float64) *PrometheusMetricFamily {
+	family := NewPrometheusMetricFamily(name, PrometheusCounter, help)
+	family.AddSample(name, nil, value)
+	return family
+}
+
+// Collects summary of GC pauses
+func (c *GoRuntimeCollector) collectGcStats() *PrometheusMetricFamily {
+	stats := &debug.GCStats{
+		PauseQuantiles: make([]time.Duration, 5),
+	}
+	debug.ReadGCStats(stats)
+
+	name := "go_gc_duration_seconds"
+	family := NewPrometheusMetricFamily(name, PrometheusSummary, "A summary of the pause duration of garbage collection cycles.")
+	quantiles := []string{"0", "0.25", "0.5", "0.75", "1"}
+	for index, quantile := range quantiles {
+		family.AddSample(name, map[string]string{"quantile": quantile}, stats.PauseQuantiles[index].Seconds())
+	}
+	family.AddSample(name+"_sum", nil, stats.PauseTotal.Seconds())
+	family.AddSample(name+"_count", nil, float64(stats.NumGC))
+	return family
+}
+
+// Collects memory statistics
+func (c *GoRuntimeCollector) collectMemStats() []*PrometheusMetricFamily {
+	stats := &runtime.MemStats{}
+	runtime.ReadMemStats(stats)
+
+	return []*PrometheusMetricFamily{
+		c.gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(stats.Alloc)),
+		c.counter("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(stats.TotalAlloc)),
+		c.gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(stats.Sys)),
+		c.counter("go_memstats_lookups_total", "Total number of pointer lookups.", float64(stats.Lookups)),
+		c.counter("go_memstats_mallocs_total", "Total number of mallocs.", float64(stats.Mallocs)),
+		c.counter("go_memstats_frees_total", "Total number of frees.", float64(stats.Frees)),
+		c.gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(stats.HeapAlloc)),
+		c.gauge("go_memstats_heap_sys_bytes", "Number of heap bytes obtained from system.", float64(stats.HeapSys)),
+		c.gauge("go_memstats_heap_idle_bytes", "Number of heap bytes waiting to be used.", float64(stats.HeapIdle)),
+		c.gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(stats.HeapInuse)),
+		c.gauge("go_memstats_heap_released_bytes", "Number of heap bytes released to OS.", float64(stats.HeapReleased)),
+		c.gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(stats.HeapObjects)),
+		c.gauge("go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", float64(stats.StackInuse)),
+		c.gauge("go_memstats_stack_sys_bytes", "Number of bytes obtained from system for stack allocator.", float64(stats.StackSys)),
+		c.gauge("go_memstats_mspan_inuse_bytes", "Number of bytes in use by mspan structures.", float64(stats.MSpanInuse)),
+		c.gauge("go_memstats_mspan_sys_bytes", "Number of bytes used for mspan structures obtained from system.", float64(stats.MSpanSys)),
+		c.gauge("go_memstats_mcache_inuse_bytes", "Number of bytes in use by mcache structures.", float64(stats.MCacheInuse)),
+		c.gauge("go_memstats_mcache_sys_bytes", "Number of bytes used for mcache structures obtained from system.", float64(stats.MCacheSys)),
+		c.gauge("go_memstats_buck_hash_sys_bytes", "Number of bytes used by the profiling bucket hash table.", float64(stats.BuckHashSys)),
+		c.gauge("go_memstats_gc_sys_bytes", "Number of bytes used for garbage collection system metadata.", float64(stats.GCSys)),
+		c.gauge("go_memstats_other_sys_bytes", "Number of bytes used for other system allocations.", float64(stats.OtherSys)),
+		c.gauge("go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(stats.NextGC)),
+		c.gauge("go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", float64(stats.LastGC)/1e9),
+		c.gauge("go_memstats_gc_cpu_fraction", "The fraction of this program's available CPU time used by the GC since the program started.", stats.GCCPUFraction),
+	}
+}
+
+// Collects histograms from runtime/metrics package
+func (c *GoRuntimeCollector) collectHistograms() []*PrometheusMetricFamily {
+	descriptions := metrics.All()
+	samples := make([]metrics.Sample, 0, len(descriptions))
+	helps := make(map[string]string)
+	for _, description := range descriptions {
+		if description.Kind == metrics.KindFloat64Histogram {
+			samples = append(samples, metrics.Sample{Name: description.Name})
+			helps[description.Name] = description.Description
+		}
+	}
+	metrics.Read(samples)
+
+	result := make([]*PrometheusMetricFamily, 0, len(samples))
+	for _, sample := range samples {
+		if sample.Value.Kind() != metrics.KindFloat64Histogram {
+			continue
+		}
+
+		name := c.histogramName(sample.Name)
+		family := NewPrometheusMetricFamily(name, PrometheusHistogram, helps[sample.Name])
+		c.addHistogramSamples(family, sample.Value.Float64Histogram())
+		result = append(result, family)
+	}
+	return result
+}
+
+// Converts runtime metric name like /sched/latencies:seconds to go_sched_latencies_seconds
+func (c *GoRuntimeCollector) histogramName(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	unit := ""
+	if index := strings.LastIndex(name, ":"); index >= 0 {
+		unit = name[index+1:]
+		name = name[:index]
+	}
+
+	result := "go_" + runtimeMetricNameRegex.ReplaceAllString(name, "_")
+	if unit != "" && unit != "*" {
+		result += "_" + runtimeMetricNameRegex.ReplaceAllString(unit, "_")
+	}
+	return result
+}
+
+// Converts runtime histogram with possibly hundreds of buckets into cumulative
+// Prometheus buckets keeping every n-th boundary
+func (c *GoRuntimeCollector) addHistogramSamples(family *PrometheusMetricFamily, histogram *metrics.Float64Histogram) {
+	bucketCount := len(histogram.Counts)
+	step := 1
+	if c.maxBuckets > 0 && bucketCount > c.maxBuckets {
+		step = (bucketCount + c.maxBuckets - 1) / c.maxBuckets
+	}
+
+	var count uint64
+	sum := 0.0
+	for index, value := range histogram.Counts {
+		count += value
+
+		// Runtime buckets are [Buckets[i], Buckets[i+1]), the sum is estimated by a finite bound
+		lower, upper := histogram.Buckets[index], histogram.Buckets[index+1]
+		estimate := lower
+		if math.IsInf(lower, -1) {
+			estimate = upper
+		} else if !math.IsInf(upper, 1) {
+			estimate = (lower + upper) / 2
+		}
+		if value > 0 && !math.IsInf(estimate, 0) {
+			sum += estimate * float64(value)
+		}
+
+		isLast := index == bucketCount-1
+		if math.IsInf(upper, 1) || (!isLast && (index+1)%step != 0) {
+			continue
+		}
+		family.AddSample(family.Name+"_bucket", map[string]string{"le": FormatSampleValue(upper)}, float64(count))
+	}
+
+	family.AddSample(family.Name+"_bucket", map[string]string{"le": "+Inf"}, float64(count))
+	family.AddSample(family.Name+"_sum", nil, sum)
+	family.AddSample(family.Name+"_count", nil, float64(count))
+}
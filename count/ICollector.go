@@ -0,0 +1,66 @@
+package count
+
+import (
+	"sync"
+)
+
+/*
+ICollector is an interface for components that compute metrics lazily at the time
+they are collected, like queue lengths or connection pool sizes.
+
+Collectors are located through references by *:metrics-collector:*:*:1.0 descriptor
+and invoked by PrometheusMetricsService on every scrape. Collectors that relay metrics
+of other processes, like PrometheusScrapeCollector, are referenced by *:metrics-relay:*:*:1.0
+descriptor instead, so PrometheusCounters don't push them again.
+
+Example:
+
+    type QueueCollector struct {
+        queue *MyQueue
+    }
+
+    func (c *QueueCollector) Collect(ch chan<- *PrometheusMetricFamily) {
+        family := NewPrometheusMetricFamily("queue_length", PrometheusGauge, "Number of messages in the queue")
+        family.AddSample("queue_length", map[string]string{"queue": c.queue.Name()}, float64(c.queue.Length()))
+        ch <- family
+    }
+*/
+type ICollector interface {
+	// Collect sends current metric families to the channel.
+	// The method is called concurrently with other collectors and shall not close the channel.
+	//   - ch    a channel to send collected metric families.
+	Collect(ch chan<- *PrometheusMetricFamily)
+}
+
+// CollectMetricFamilies calls the given collectors concurrently and merges their results.
+//   - collectors    collectors to call.
+// Returns []*PrometheusMetricFamily
+// merged metric families sorted by names
+func CollectMetricFamilies(collectors []ICollector) []*PrometheusMetricFamily {
+	families := make([]*PrometheusMetricFamily, 0)
+	if len(collectors) == 0 {
+		return families
+	}
+
+	ch := make(chan *PrometheusMetricFamily)
+	var wg sync.WaitGroup
+	wg.Add(len(collectors))
+	for _, collector := range collectors {
+		go func(collector ICollector) {
+			defer wg.Done()
+			collector.Collect(ch)
+		}(collector)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	for family := range ch {
+		if family != nil {
+			families = append(families, family)
+		}
+	}
+
+	return PrometheusCounterConverter.MergeFamilies(families)
+}
@@ -0,0 +1,16 @@
+package count
+
+import (
+	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
+)
+
+/*
+ICountersReader is an interface for counters that keep their measurements in memory
+and can return them, like CachedCounters and all components that embed it:
+PrometheusCounters, LogCounters and others.
+*/
+type ICountersReader interface {
+	// GetAll gets all captured counters.
+	// Returns []*ccount.Counter
+	GetAll() []*ccount.Counter
+}
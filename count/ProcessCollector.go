@@ -0,0 +1,176 @@
+package count
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+)
+
+/*
+ProcessCollector is a collector that exposes metrics of the current process read from /proc filesystem on Linux:
+process_cpu_seconds_total, process_resident_memory_bytes, process_virtual_memory_bytes,
+process_open_fds, process_max_fds and process_start_time_seconds.
+
+Metrics that can't be read, for instance on other operating systems, are silently skipped.
+
+Configuration parameters:
+
+  - options:
+    - proc_path:             path where proc filesystem is mounted (default: /proc)
+    - pid:                   process id or "self" for the current process (default: self)
+
+Example:
+
+    collector := NewProcessCollector()
+
+    references := cref.NewReferencesFromTuples(
+        cref.NewDescriptor("pip-services", "metrics-collector", "process", "default", "1.0"), collector,
+    )
+*/
+type ProcessCollector struct {
+	procPath string
+	pid      string
+}
+
+// Number of clock ticks per second used by /proc/[pid]/stat, fixed to 100 on Linux
+const processClockTicks = 100
+
+// NewProcessCollector creates a new instance of the collector.
+// Returns *ProcessCollector
+// pointer on new instance
+func NewProcessCollector() *ProcessCollector {
+	return &ProcessCollector{
+		procPath: "/proc",
+		pid:      "self",
+	}
+}
+
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+// - config   *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *ProcessCollector) Configure(config *cconf.ConfigParams) {
+	c.procPath = config.GetAsStringWithDefault("options.proc_path", c.procPath)
+	c.pid = config.GetAsStringWithDefault("options.pid", c.pid)
+}
+
+// Collect method sends current process metrics to the channel.
+//   - ch    a channel to send collected metric families.
+func (c *ProcessCollector) Collect(ch chan<- *PrometheusMetricFamily) {
+	processPath := filepath.Join(c.procPath, c.pid)
+
+	if stat, err := c.readProcessStat(processPath); err == nil {
+		utime, _ := strconv.ParseFloat(stat[11], 64)
+		stime, _ := strconv.ParseFloat(stat[12], 64)
+		ch <- c.family("process_cpu_seconds_total", PrometheusCounter,
+			"Total user and system CPU time spent in seconds.", (utime+stime)/processClockTicks)
+
+		vsize, _ := strconv.ParseFloat(stat[20], 64)
+		ch <- c.family("process_virtual_memory_bytes", PrometheusGauge,
+			"Virtual memory size in bytes.", vsize)
+
+		rss, _ := strconv.ParseFloat(stat[21], 64)
+		ch <- c.family("process_resident_memory_bytes", PrometheusGauge,
+			"Resident memory size in bytes.", rss*float64(os.Getpagesize()))
+
+		if bootTime, err := c.readBootTime(); err == nil {
+			startTime, _ := strconv.ParseFloat(stat[19], 64)
+			ch <- c.family("process_start_time_seconds", PrometheusGauge,
+				"Start time of the process since unix epoch in seconds.", bootTime+startTime/processClockTicks)
+		}
+	}
+
+	if files, err := ioutil.ReadDir(filepath.Join(processPath, "fd")); err == nil {
+		ch <- c.family("process_open_fds", PrometheusGauge,
+			"Number of open file descriptors.", float64(len(files)))
+	}
+
+	if maxFds, err := c.readMaxFds(processPath); err == nil {
+		ch <- c.family("process_max_fds", PrometheusGauge,
+			"Maximum number of open file descriptors.", maxFds)
+	}
+}
+
+func (c *ProcessCollector) family(name string, typ string, help string, value float64) *PrometheusMetricFamily {
+	family := NewPrometheusMetricFamily(name, typ, help)
+	family.AddSample(name, nil, value)
+	return family
+}
+
+// Reads fields of /proc/[pid]/stat that follow the command name.
+// The command is in parentheses and may contain spaces, so fields are counted from the last ")".
+// Field with index 0 is the process state (field 3 in proc(5) numbering).
+func (c *ProcessCollector) readProcessStat(processPath string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(processPath, "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	text := string(data)
+	index := strings.LastIndex(text, ")")
+	if index < 0 {
+		return nil, os.ErrInvalid
+	}
+
+	fields := strings.Fields(text[index+1:])
+	if len(fields) < 22 {
+		return nil, os.ErrInvalid
+	}
+	return fields, nil
+}
+
+// Reads system boot time in seconds since unix epoch from btime line of /proc/stat
+func (c *ProcessCollector) readBootTime() (float64, error) {
+	file, err := os.Open(filepath.Join(c.procPath, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			return strconv.ParseFloat(fields[1], 64)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, os.ErrNotExist
+}
+
+// Reads soft limit of open files from /proc/[pid]/limits
+func (c *ProcessCollector) readMaxFds(processPath string) (float64, error) {
+	file, err := os.Open(filepath.Join(processPath, "limits"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			return 0, os.ErrInvalid
+		}
+		if fields[0] == "unlimited" {
+			return float64(^uint64(0)), nil
+		}
+		return strconv.ParseFloat(fields[0], 64)
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, os.ErrNotExist
+}
@@ -1,9 +1,11 @@
 package count
 
 import (
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 
-	cconv "github.com/pip-services3-go/pip-services3-commons-go/convert"
 	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
 )
 
@@ -26,85 +28,339 @@ func (c *TPrometheusCounterConverter) ToString(counters []*ccount.Counter, sourc
 		return ""
 	}
 
-	var builder string = ""
+	return c.FamiliesToString(c.ToMetricFamilies(counters, source, instance))
+}
+
+// ToMetricFamilies method converts the given counters into Prometheus metric families.
+// Counters that map to the same metric name (like exec_time of different commands)
+// are grouped into one family. Families are sorted by names.
+//   - counters  a list of counters to convert.
+//   - source    a source (context) name.
+//   - instance  a unique instance name (usually a host name).
+// Returns []*PrometheusMetricFamily
+// converted metric families
+func (c *TPrometheusCounterConverter) ToMetricFamilies(counters []*ccount.Counter, source string, instance string) []*PrometheusMetricFamily {
+	return c.toMetricFamilies(counters, nil, false, source, instance)
+}
+
+// ToMetricFamiliesWithExemplars method converts the given counters into Prometheus metric families
+// for OpenMetrics output. Unlike ToMetricFamilies it exposes increment counters as counter families
+// and attaches exemplars to them. OpenMetrics allows exemplars only on counters and histogram buckets,
+// so exemplars of other counters are not attached. Exemplars of timings are exposed by timing histograms
+// of PrometheusCounters.
+//   - counters   a list of counters to convert.
+//   - exemplars  exemplars by counter names, can be nil.
+//   - source     a source (context) name.
+//   - instance   a unique instance name (usually a host name).
+// Returns []*PrometheusMetricFamily
+// converted metric families
+func (c *TPrometheusCounterConverter) ToMetricFamiliesWithExemplars(counters []*ccount.Counter,
+	exemplars map[string]*PrometheusExemplar, source string, instance string) []*PrometheusMetricFamily {
+	return c.toMetricFamilies(counters, exemplars, true, source, instance)
+}
+
+// Converts counters into metric families, increment counters are exposed as counter families when typed is true
+func (c *TPrometheusCounterConverter) toMetricFamilies(counters []*ccount.Counter,
+	exemplars map[string]*PrometheusExemplar, typed bool, source string, instance string) []*PrometheusMetricFamily {
+	result := make([]*PrometheusMetricFamily, 0)
+	families := make(map[string]*PrometheusMetricFamily)
+
+	add := func(name string, typ string, labels map[string]string, value float64) *PrometheusSample {
+		family, ok := families[name]
+		if !ok {
+			family = NewPrometheusMetricFamily(name, typ, "")
+			families[name] = family
+			result = append(result, family)
+		}
+		sampleLabels := make(map[string]string, len(labels))
+		for key, value := range labels {
+			sampleLabels[key] = value
+		}
+		return family.AddSample(name, sampleLabels, value)
+	}
 
 	for _, counter := range counters {
+		if counter == nil {
+			continue
+		}
+
 		counterName := c.parseCounterName(counter)
-		labels := c.generateCounterLabel(counter, source, instance)
+		labels := c.parseCounterLabels(counter, source, instance)
 
+		exemplar := exemplars[counter.Name]
+
+		// Prometheus doesn't support non-numeric metrics, so other counters are exposed as gauges
 		switch counter.Type {
 		case ccount.Increment:
-			builder += "# TYPE " + counterName + " gauge\n"
-			builder += counterName + labels + " " + cconv.StringConverter.ToString(counter.Count) + "\n"
-			break
-		case ccount.Interval:
-			builder += "# TYPE " + counterName + "_max gauge\n"
-			builder += counterName + "_max" + labels + " " + cconv.StringConverter.ToString(counter.Max) + "\n"
-			builder += "# TYPE " + counterName + "_min gauge\n"
-			builder += counterName + "_min" + labels + " " + cconv.StringConverter.ToString(counter.Min) + "\n"
-			builder += "# TYPE " + counterName + "_average gauge\n"
-			builder += counterName + "_average" + labels + " " + cconv.StringConverter.ToString(counter.Average) + "\n"
-			builder += "# TYPE " + counterName + "_count gauge\n"
-			builder += counterName + "_count" + labels + " " + cconv.StringConverter.ToString(counter.Count) + "\n"
-			break
+			if typed {
+				add(counterName, PrometheusCounter, labels, float64(counter.Count)).Exemplar = exemplar
+			} else {
+				add(counterName, PrometheusGauge, labels, float64(counter.Count))
+			}
+		case ccount.Interval, ccount.Statistics:
+			add(counterName+"_max", PrometheusGauge, labels, c.toFloat64(counter.Max))
+			add(counterName+"_min", PrometheusGauge, labels, c.toFloat64(counter.Min))
+			add(counterName+"_average", PrometheusGauge, labels, c.toFloat64(counter.Average))
+			add(counterName+"_count", PrometheusGauge, labels, float64(counter.Count))
 		case ccount.LastValue:
-			builder += "# TYPE " + counterName + " gauge\n"
-			builder += counterName + labels + " " + cconv.StringConverter.ToString(counter.Last) + "\n"
-			break
-		case ccount.Statistics:
-			builder += "# TYPE " + counterName + "_max gauge\n"
-			builder += counterName + "_max" + labels + " " + cconv.StringConverter.ToString(counter.Max) + "\n"
-			builder += "# TYPE " + counterName + "_min gauge\n"
-			builder += counterName + "_min" + labels + " " + cconv.StringConverter.ToString(counter.Min) + "\n"
-			builder += "# TYPE " + counterName + "_average gauge\n"
-			builder += counterName + "_average" + labels + " " + cconv.StringConverter.ToString(counter.Average) + "\n"
-			builder += "# TYPE " + counterName + "_count gauge\n"
-			builder += counterName + "_count" + labels + " " + cconv.StringConverter.ToString(counter.Count) + "\n"
-			break
-		case ccount.Timestamp: // Prometheus doesn't support non-numeric metrics
-			builder += "# TYPE " + counterName + " gauge\n" //" untyped\n"
-			builder += counterName + labels + " " + cconv.StringConverter.ToString(counter.Time.Unix()) + "\n"
-			break
+			add(counterName, PrometheusGauge, labels, c.toFloat64(counter.Last))
+		case ccount.Timestamp:
+			add(counterName, PrometheusGauge, labels, float64(counter.Time.Unix()))
 		}
 	}
 
-	return builder
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
 }
 
-func (c *TPrometheusCounterConverter) generateCounterLabel(counter *ccount.Counter, source string, instance string) string {
+// FamiliesToString method writes the given metric families in Prometheus text exposition format.
+//   - families  a list of metric families to write.
+// Returns string
+// metrics in text exposition format
+func (c *TPrometheusCounterConverter) FamiliesToString(families []*PrometheusMetricFamily) string {
+	var builder strings.Builder
 
-	labels := make(map[string]string, 0)
+	for _, family := range families {
+		if family == nil || len(family.Samples) == 0 {
+			continue
+		}
 
-	if source != "" {
-		labels["source"] = source
+		if family.Help != "" {
+			builder.WriteString("# HELP " + family.Name + " " + c.escapeHelp(family.Help) + "\n")
+		}
+		typ := family.Type
+		if typ == "" {
+			typ = PrometheusUntyped
+		}
+		builder.WriteString("# TYPE " + family.Name + " " + typ + "\n")
+
+		for _, sample := range family.Samples {
+			builder.WriteString(sample.Key())
+			builder.WriteString(" ")
+			builder.WriteString(FormatSampleValue(sample.Value))
+			if sample.Timestamp != 0 {
+				builder.WriteString(" ")
+				builder.WriteString(strconv.FormatInt(sample.Timestamp, 10))
+			}
+			builder.WriteString("\n")
+		}
 	}
 
-	if instance != "" {
-		labels["instance"] = instance
+	return builder.String()
+}
+
+// FamiliesToOpenMetrics method writes the given metric families in OpenMetrics text format
+// with exemplars. Counter families are named without _total suffix and their samples with it,
+// timestamps are written in seconds and the output is terminated by # EOF line.
+// Exemplars are written only for _total samples of counters and _bucket samples of histograms.
+//   - families  a list of metric families to write.
+// Returns string
+// metrics in OpenMetrics text format
+func (c *TPrometheusCounterConverter) FamiliesToOpenMetrics(families []*PrometheusMetricFamily) string {
+	var builder strings.Builder
+
+	for _, family := range families {
+		if family == nil || len(family.Samples) == 0 {
+			continue
+		}
+
+		name := family.Name
+		typ := family.Type
+		switch typ {
+		case PrometheusCounter:
+			name = strings.TrimSuffix(name, "_total")
+		case "", PrometheusUntyped:
+			typ = "unknown"
+		}
+
+		if family.Help != "" {
+			builder.WriteString("# HELP " + name + " " + c.escapeOpenMetricsHelp(family.Help) + "\n")
+		}
+		builder.WriteString("# TYPE " + name + " " + typ + "\n")
+
+		for _, sample := range family.Samples {
+			if typ == PrometheusCounter && !strings.HasSuffix(sample.Name, "_total") &&
+				!strings.HasSuffix(sample.Name, "_created") {
+				sample = &PrometheusSample{Name: sample.Name + "_total", Labels: sample.Labels,
+					Value: sample.Value, Timestamp: sample.Timestamp, Exemplar: sample.Exemplar}
+			}
+
+			builder.WriteString(sample.Key())
+			builder.WriteString(" ")
+			builder.WriteString(FormatSampleValue(sample.Value))
+			if sample.Timestamp != 0 {
+				builder.WriteString(" ")
+				builder.WriteString(c.formatOpenMetricsTimestamp(sample.Timestamp))
+			}
+			if sample.Exemplar != nil && c.allowsExemplar(typ, sample.Name) {
+				// Sample key without a name is just a label set, which is empty for no labels
+				labels := (&PrometheusSample{Labels: sample.Exemplar.Labels}).Key()
+				if labels == "" {
+					labels = "{}"
+				}
+				builder.WriteString(" # ")
+				builder.WriteString(labels)
+				builder.WriteString(" ")
+				builder.WriteString(FormatSampleValue(sample.Exemplar.Value))
+				if sample.Exemplar.Timestamp != 0 {
+					builder.WriteString(" ")
+					builder.WriteString(c.formatOpenMetricsTimestamp(sample.Exemplar.Timestamp))
+				}
+			}
+			builder.WriteString("\n")
+		}
 	}
 
-	nameParts := strings.Split(counter.Name, ".")
+	builder.WriteString("# EOF\n")
+	return builder.String()
+}
 
-	// If there are other predictable names from which we can parse labels, we can add them below
-	if len(nameParts) >= 3 && nameParts[2] == "exec_time" {
-		labels["service"] = nameParts[0]
-		labels["command"] = nameParts[1]
+// Checks if OpenMetrics allows an exemplar on the sample of a family with the given type
+func (c *TPrometheusCounterConverter) allowsExemplar(typ string, sampleName string) bool {
+	switch typ {
+	case PrometheusCounter:
+		return strings.HasSuffix(sampleName, "_total")
+	case PrometheusHistogram:
+		return strings.HasSuffix(sampleName, "_bucket")
+	}
+	return false
+}
+
+// MergeFamilies method merges families with the same names into one family
+// keeping type and help of the first one. Result is sorted by names.
+//   - families  metric families to merge.
+// Returns []*PrometheusMetricFamily
+// merged metric families
+func (c *TPrometheusCounterConverter) MergeFamilies(families []*PrometheusMetricFamily) []*PrometheusMetricFamily {
+	result := make([]*PrometheusMetricFamily, 0, len(families))
+	merged := make(map[string]*PrometheusMetricFamily)
+
+	for _, family := range families {
+		if family == nil {
+			continue
+		}
+
+		target, ok := merged[family.Name]
+		if !ok {
+			target = NewPrometheusMetricFamily(family.Name, family.Type, family.Help)
+			merged[family.Name] = target
+			result = append(result, target)
+		}
+		target.Samples = append(target.Samples, family.Samples...)
 	}
 
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// AddLabels method adds labels to all samples of the given families.
+// Labels already set in samples are not overridden.
+//   - families  metric families to update.
+//   - labels    labels to add.
+// Returns []*PrometheusMetricFamily
+// the same families
+func (c *TPrometheusCounterConverter) AddLabels(families []*PrometheusMetricFamily, labels map[string]string) []*PrometheusMetricFamily {
 	if len(labels) == 0 {
-		return ""
+		return families
 	}
 
-	builder := "{"
-	for key, _ := range labels {
-		if len(builder) > 1 {
-			builder += ","
+	for _, family := range families {
+		for _, sample := range family.Samples {
+			sampleLabels := make(map[string]string, len(sample.Labels)+len(labels))
+			for key, value := range labels {
+				if value != "" {
+					sampleLabels[key] = value
+				}
+			}
+			for key, value := range sample.Labels {
+				sampleLabels[key] = value
+			}
+			sample.Labels = sampleLabels
 		}
-		builder += key + `="` + labels[key] + `"`
 	}
-	builder += "}"
 
-	return builder
+	return families
+}
+
+// ExportLabels method sets labels to all samples of the given families.
+// Different values already set in samples are kept in labels with exported_ prefix,
+// the same way Prometheus keeps conflicting labels of scraped targets.
+//   - families  metric families to update.
+//   - labels    labels to set, labels with empty values are skipped.
+// Returns []*PrometheusMetricFamily
+// the same families
+func (c *TPrometheusCounterConverter) ExportLabels(families []*PrometheusMetricFamily, labels map[string]string) []*PrometheusMetricFamily {
+	for _, family := range families {
+		for _, sample := range family.Samples {
+			sampleLabels := make(map[string]string, len(sample.Labels)+len(labels))
+			for key, value := range sample.Labels {
+				sampleLabels[key] = value
+			}
+			for key, value := range labels {
+				if value == "" {
+					continue
+				}
+				if exported, ok := sampleLabels[key]; ok && exported != value {
+					sampleLabels["exported_"+key] = exported
+				}
+				sampleLabels[key] = value
+			}
+			sample.Labels = sampleLabels
+		}
+	}
+
+	return families
+}
+
+// FormatSampleValue formats a sample value as required by Prometheus text exposition format.
+func FormatSampleValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	abs := math.Abs(value)
+	if abs >= 1e21 || (abs != 0 && abs < 1e-6) {
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (c *TPrometheusCounterConverter) escapeHelp(help string) string {
+	help = strings.Replace(help, `\`, `\\`, -1)
+	help = strings.Replace(help, "\n", `\n`, -1)
+	return help
+}
+
+func (c *TPrometheusCounterConverter) escapeOpenMetricsHelp(help string) string {
+	help = c.escapeHelp(help)
+	help = strings.Replace(help, `"`, `\"`, -1)
+	return help
+}
+
+// Formats a timestamp in milliseconds as seconds with fraction
+func (c *TPrometheusCounterConverter) formatOpenMetricsTimestamp(timestamp int64) string {
+	return strconv.FormatFloat(float64(timestamp)/1000, 'f', -1, 64)
+}
+
+// Converts float32 counter values keeping their shortest decimal representation,
+// so 0.1 stays 0.1 instead of 0.10000000149011612
+func (c *TPrometheusCounterConverter) toFloat64(value float32) float64 {
+	result, err := strconv.ParseFloat(strconv.FormatFloat(float64(value), 'g', -1, 32), 64)
+	if err != nil {
+		return float64(value)
+	}
+	return result
+}
+
+// Returns name and labels of the histogram that records timings of the counter with the given name
+func (c *TPrometheusCounterConverter) timingHistogram(name string) (string, map[string]string) {
+	counter := ccount.NewCounter(name, ccount.Interval)
+	return c.parseCounterName(counter) + "_seconds", c.parseCounterLabels(counter, "", "")
 }
 
 func (c *TPrometheusCounterConverter) parseCounterName(counter *ccount.Counter) string {
@@ -128,7 +384,7 @@ func (c *TPrometheusCounterConverter) parseCounterName(counter *ccount.Counter)
 	return result
 }
 
-func (c *TPrometheusCounterConverter) parseCounterLabels(counter *ccount.Counter, source string, instance string) interface{} {
+func (c *TPrometheusCounterConverter) parseCounterLabels(counter *ccount.Counter, source string, instance string) map[string]string {
 	labels := make(map[string]string, 0)
 
 	if source != "" {
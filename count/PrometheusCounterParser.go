@@ -0,0 +1,676 @@
+package count
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
+)
+
+//  PrometheusCounterParser is helper class that reads metrics in Prometheus text exposition
+//  or OpenMetrics format back into metric families and performance counters.
+var PrometheusCounterParser TPrometheusCounterParser = TPrometheusCounterParser{}
+
+type TPrometheusCounterParser struct {
+}
+
+// Parse method reads metrics in the format defined by the content type of a scrape response.
+// OpenMetrics is parsed for application/openmetrics-text, text exposition format otherwise.
+//   - correlationId   (optional) transaction id to trace execution through call chain.
+//   - contentType     a content type of the metrics.
+//   - text            metrics to parse.
+// Returns []*PrometheusMetricFamily, error
+// parsed metric families or BadRequestError with the line number if metrics are invalid.
+func (c *TPrometheusCounterParser) Parse(correlationId string, contentType string,
+	text string) ([]*PrometheusMetricFamily, error) {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType == "application/openmetrics-text" {
+		return c.ParseOpenMetrics(correlationId, text)
+	}
+	return c.ParseText(correlationId, text)
+}
+
+// ParseText method reads metrics in Prometheus text exposition format.
+// Samples without TYPE line are read into untyped families. Families are returned in the order
+// of their appearance, timestamps are in milliseconds.
+//   - correlationId   (optional) transaction id to trace execution through call chain.
+//   - text            metrics to parse.
+// Returns []*PrometheusMetricFamily, error
+// parsed metric families or BadRequestError with the line number if metrics are invalid.
+func (c *TPrometheusCounterParser) ParseText(correlationId string, text string) ([]*PrometheusMetricFamily, error) {
+	parser := newPrometheusTextParser(correlationId, false)
+	return parser.parse(text)
+}
+
+// ParseOpenMetrics method reads metrics in OpenMetrics text format with exemplars.
+// Counter families get _total suffix like families written by FamiliesToOpenMetrics,
+// unknown type is read as untyped, info and stateset as gauges and gaugehistogram as untyped.
+// Timestamps are converted from seconds into milliseconds. Metrics must end with # EOF line.
+// Exemplars are accepted only on _total samples of counters and _bucket samples of histograms.
+//   - correlationId   (optional) transaction id to trace execution through call chain.
+//   - text            metrics to parse.
+// Returns []*PrometheusMetricFamily, error
+// parsed metric families or BadRequestError with the line number if metrics are invalid.
+func (c *TPrometheusCounterParser) ParseOpenMetrics(correlationId string, text string) ([]*PrometheusMetricFamily, error) {
+	parser := newPrometheusTextParser(correlationId, true)
+	return parser.parse(text)
+}
+
+// ToCounters method converts metric families into performance counters.
+// It reverses ToMetricFamilies: gauges with _max, _min, _average and _count samples become
+// statistics counters, exec_time samples with service and command labels get their original names.
+// Other samples become last value counters, counters become increments, histograms and summaries
+// become interval counters with count and average. Labels except source and instance
+// are added to counter names like name{label="value"}.
+//   - families    metric families to convert.
+// Returns []*ccount.Counter
+// converted counters
+func (c *TPrometheusCounterParser) ToCounters(families []*PrometheusMetricFamily) []*ccount.Counter {
+	result := make([]*ccount.Counter, 0)
+	counters := make(map[string]*ccount.Counter)
+	statistics := make(map[string]map[string]bool)
+
+	get := func(name string, typ int, timestamp int64) *ccount.Counter {
+		counter, ok := counters[name]
+		if !ok {
+			counter = ccount.NewCounter(name, typ)
+			counter.Time = time.Now()
+			counters[name] = counter
+			result = append(result, counter)
+		}
+		if timestamp != 0 {
+			counter.Time = time.Unix(0, timestamp*int64(time.Millisecond))
+		}
+		return counter
+	}
+
+	// Statistics counters are written as separate gauge families with suffixes
+	suffixes := []string{"_max", "_min", "_average", "_count"}
+	for _, family := range families {
+		if family == nil || (family.Type != PrometheusGauge && family.Type != PrometheusUntyped) {
+			continue
+		}
+		for _, sample := range family.Samples {
+			for _, suffix := range suffixes {
+				if strings.HasSuffix(sample.Name, suffix) {
+					name := c.counterName(strings.TrimSuffix(sample.Name, suffix), sample.Labels)
+					if statistics[name] == nil {
+						statistics[name] = make(map[string]bool)
+					}
+					statistics[name][suffix] = true
+				}
+			}
+		}
+	}
+
+	for _, family := range families {
+		if family == nil {
+			continue
+		}
+
+		for _, sample := range family.Samples {
+			switch family.Type {
+			case PrometheusCounter:
+				counter := get(c.counterName(sample.Name, sample.Labels), ccount.Increment, sample.Timestamp)
+				counter.Count = int(sample.Value)
+			case PrometheusHistogram, PrometheusSummary:
+				name := c.counterName(family.Name, sample.Labels)
+				switch sample.Name {
+				case family.Name + "_count":
+					counter := get(name, ccount.Interval, sample.Timestamp)
+					counter.Count = int(sample.Value)
+				case family.Name + "_sum":
+					counter := get(name, ccount.Interval, sample.Timestamp)
+					counter.Average = float32(sample.Value)
+				}
+			default:
+				handled := false
+				for _, suffix := range suffixes {
+					if !strings.HasSuffix(sample.Name, suffix) {
+						continue
+					}
+					name := c.counterName(strings.TrimSuffix(sample.Name, suffix), sample.Labels)
+					if len(statistics[name]) != len(suffixes) {
+						continue
+					}
+
+					counter := get(name, ccount.Statistics, sample.Timestamp)
+					switch suffix {
+					case "_max":
+						counter.Max = float32(sample.Value)
+					case "_min":
+						counter.Min = float32(sample.Value)
+					case "_average":
+						counter.Average = float32(sample.Value)
+					case "_count":
+						counter.Count = int(sample.Value)
+					}
+					handled = true
+				}
+				if !handled {
+					counter := get(c.counterName(sample.Name, sample.Labels), ccount.LastValue, sample.Timestamp)
+					counter.Last = float32(sample.Value)
+				}
+			}
+		}
+	}
+
+	// Histograms and summaries have sums in place of averages until counts are known
+	for _, counter := range result {
+		if counter.Type == ccount.Interval {
+			if counter.Count > 0 {
+				counter.Average = counter.Average / float32(counter.Count)
+			} else {
+				counter.Average = 0
+			}
+		}
+	}
+
+	return result
+}
+
+// Restores a counter name from a metric name and labels
+func (c *TPrometheusCounterParser) counterName(name string, labels map[string]string) string {
+	rest := make(map[string]string, len(labels))
+	for key, value := range labels {
+		if key != "source" && key != "instance" {
+			rest[key] = value
+		}
+	}
+
+	service, hasService := rest["service"]
+	command, hasCommand := rest["command"]
+	if name == "exec_time" && hasService && hasCommand {
+		name = service + "." + command + "." + name
+		delete(rest, "service")
+		delete(rest, "command")
+	}
+
+	return (&PrometheusSample{Name: name, Labels: rest}).Key()
+}
+
+// Metadata and suffixes of sample names of a family being parsed
+type prometheusParsedFamily struct {
+	family   *PrometheusMetricFamily
+	name     string
+	suffixes []string
+	typed    bool
+	helped   bool
+}
+
+func (c *prometheusParsedFamily) matches(name string) bool {
+	for _, suffix := range c.suffixes {
+		if name == c.name+suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses metrics line by line with a simple recursive descent over each line
+type prometheusTextParser struct {
+	correlationId string
+	openMetrics   bool
+	families      []*prometheusParsedFamily
+	names         map[string]*prometheusParsedFamily
+	current       *prometheusParsedFamily
+	line          int
+	text          string
+	position      int
+}
+
+func newPrometheusTextParser(correlationId string, openMetrics bool) *prometheusTextParser {
+	return &prometheusTextParser{
+		correlationId: correlationId,
+		openMetrics:   openMetrics,
+		families:      make([]*prometheusParsedFamily, 0),
+		names:         make(map[string]*prometheusParsedFamily),
+	}
+}
+
+func (c *prometheusTextParser) error(message string) error {
+	return cerr.NewBadRequestError(c.correlationId, "INVALID_METRICS",
+		"Invalid metrics at line "+strconv.Itoa(c.line)+": "+message).
+		WithDetails("line", c.line).WithDetails("text", c.text)
+}
+
+func (c *prometheusTextParser) parse(text string) ([]*PrometheusMetricFamily, error) {
+	lines := strings.Split(text, "\n")
+	// Metrics end with a line feed, so the last part is not a line
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	eof := false
+	for index, line := range lines {
+		c.line = index + 1
+		c.text = strings.TrimSuffix(line, "\r")
+		c.position = 0
+
+		if eof {
+			return nil, c.error("Unexpected content after # EOF")
+		}
+
+		var err error
+		switch {
+		case strings.TrimSpace(c.text) == "":
+			if c.openMetrics {
+				err = c.error("Empty lines are not allowed")
+			}
+		case c.openMetrics && c.text == "# EOF":
+			eof = true
+		case strings.HasPrefix(c.text, "#"):
+			err = c.parseComment()
+		default:
+			err = c.parseSample()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.openMetrics && !eof {
+		c.line = len(lines)
+		return nil, c.error("Expected # EOF at the end")
+	}
+
+	result := make([]*PrometheusMetricFamily, 0, len(c.families))
+	for _, parsed := range c.families {
+		result = append(result, parsed.family)
+	}
+	return result, nil
+}
+
+// Parses HELP and TYPE lines, other comments are ignored
+func (c *prometheusTextParser) parseComment() error {
+	c.position = 1
+	c.skipSpaces()
+	keyword := c.readToken()
+	if keyword != "HELP" && keyword != "TYPE" {
+		return nil
+	}
+
+	c.skipSpaces()
+	name := c.readName(true)
+	if name == "" {
+		return c.error("Expected metric name in " + keyword)
+	}
+	if c.position < len(c.text) && c.text[c.position] != ' ' && c.text[c.position] != '\t' {
+		return c.error("Invalid metric name in " + keyword)
+	}
+	c.skipSpaces()
+	value := c.text[c.position:]
+
+	parsed := c.familyFor(name, true)
+
+	if keyword == "HELP" {
+		if parsed.helped {
+			return c.error("Duplicate HELP for " + name)
+		}
+		parsed.helped = true
+		parsed.family.Help = c.unescapeHelp(value)
+		return nil
+	}
+
+	if parsed.typed {
+		return c.error("Duplicate TYPE for " + name)
+	}
+	if len(parsed.family.Samples) > 0 {
+		return c.error("TYPE for " + name + " after its samples")
+	}
+	return c.setType(parsed, strings.TrimSpace(value))
+}
+
+func (c *prometheusTextParser) setType(parsed *prometheusParsedFamily, typ string) error {
+	parsed.typed = true
+
+	switch typ {
+	case PrometheusGauge:
+		parsed.family.Type = PrometheusGauge
+	case PrometheusCounter:
+		parsed.family.Type = PrometheusCounter
+		if c.openMetrics {
+			// Counters are named with _total suffix like in text format
+			parsed.family.Name = parsed.name + "_total"
+			parsed.suffixes = []string{"_total", "_created"}
+		}
+	case PrometheusHistogram:
+		parsed.family.Type = PrometheusHistogram
+		parsed.suffixes = []string{"_bucket", "_sum", "_count"}
+		if c.openMetrics {
+			parsed.suffixes = append(parsed.suffixes, "_created")
+		}
+	case PrometheusSummary:
+		parsed.family.Type = PrometheusSummary
+		parsed.suffixes = []string{"", "_sum", "_count"}
+		if c.openMetrics {
+			parsed.suffixes = append(parsed.suffixes, "_created")
+		}
+	case PrometheusUntyped:
+		if c.openMetrics {
+			return c.error("Unsupported type " + typ)
+		}
+		parsed.family.Type = PrometheusUntyped
+	default:
+		if !c.openMetrics {
+			return c.error("Unsupported type " + typ)
+		}
+		switch typ {
+		case "unknown":
+			parsed.family.Type = PrometheusUntyped
+		case "info":
+			parsed.family.Type = PrometheusGauge
+			parsed.suffixes = []string{"_info"}
+		case "stateset":
+			parsed.family.Type = PrometheusGauge
+		case "gaugehistogram":
+			parsed.family.Type = PrometheusUntyped
+			parsed.suffixes = []string{"_bucket", "_gsum", "_gcount"}
+		default:
+			return c.error("Unsupported type " + typ)
+		}
+	}
+	return nil
+}
+
+// Finds a family of the metadata or the sample with the given name or starts a new one
+func (c *prometheusTextParser) familyFor(name string, metadata bool) *prometheusParsedFamily {
+	if metadata {
+		if parsed, ok := c.names[name]; ok {
+			c.current = parsed
+			return parsed
+		}
+	} else {
+		if c.current != nil && c.current.matches(name) {
+			return c.current
+		}
+		for _, suffix := range []string{"", "_total", "_created", "_bucket", "_sum", "_count", "_gsum", "_gcount", "_info"} {
+			if parsed, ok := c.names[strings.TrimSuffix(name, suffix)]; ok && parsed.matches(name) {
+				c.current = parsed
+				return parsed
+			}
+		}
+	}
+
+	parsed := &prometheusParsedFamily{
+		family:   NewPrometheusMetricFamily(name, PrometheusUntyped, ""),
+		name:     name,
+		suffixes: []string{""},
+	}
+	c.families = append(c.families, parsed)
+	c.names[name] = parsed
+	c.current = parsed
+	return parsed
+}
+
+// Parses a sample line: name{labels} value [timestamp] [# {labels} value [timestamp]]
+func (c *prometheusTextParser) parseSample() error {
+	name := c.readName(true)
+	if name == "" {
+		return c.error("Expected metric name")
+	}
+
+	labels := make(map[string]string)
+	if c.position < len(c.text) && c.text[c.position] == '{' {
+		var err error
+		labels, err = c.readLabels()
+		if err != nil {
+			return err
+		}
+	}
+
+	rest := c.text[c.position:]
+	if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return c.error("Expected value after metric name")
+	}
+
+	exemplarText := ""
+	if index := strings.Index(rest, "#"); index >= 0 {
+		if !c.openMetrics {
+			return c.error("Exemplars are supported only in OpenMetrics")
+		}
+		exemplarText = strings.TrimSpace(rest[index+1:])
+		rest = rest[:index]
+	}
+
+	sample := &PrometheusSample{Name: name, Labels: labels}
+	var err error
+	sample.Value, sample.Timestamp, err = c.parseValue(rest)
+	if err != nil {
+		return err
+	}
+
+	if exemplarText != "" {
+		sample.Exemplar, err = c.parseExemplar(exemplarText)
+		if err != nil {
+			return err
+		}
+	}
+
+	parsed := c.familyFor(name, false)
+	if sample.Exemplar != nil && !PrometheusCounterConverter.allowsExemplar(parsed.family.Type, name) {
+		return c.error("Exemplars are allowed only on counter totals and histogram buckets")
+	}
+	parsed.family.Samples = append(parsed.family.Samples, sample)
+	return nil
+}
+
+// Parses a value with an optional timestamp
+func (c *prometheusTextParser) parseValue(text string) (float64, int64, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, c.error("Expected value and optional timestamp")
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, 0, c.error("Invalid value " + fields[0])
+	}
+
+	var timestamp int64
+	if len(fields) == 2 {
+		timestamp, err = c.parseTimestamp(fields[1])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return value, timestamp, nil
+}
+
+// Parses a timestamp in milliseconds or in seconds in OpenMetrics
+func (c *prometheusTextParser) parseTimestamp(text string) (int64, error) {
+	if !c.openMetrics {
+		timestamp, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return 0, c.error("Invalid timestamp " + text)
+		}
+		return timestamp, nil
+	}
+
+	seconds, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, c.error("Invalid timestamp " + text)
+	}
+	return int64(math.Round(seconds * 1000)), nil
+}
+
+func (c *prometheusTextParser) parseExemplar(text string) (*PrometheusExemplar, error) {
+	c.text, text = text, c.text
+	c.position = 0
+	// Errors are reported for the whole line
+	defer func() { c.text = text }()
+
+	if !strings.HasPrefix(c.text, "{") {
+		return nil, c.error("Expected labels of exemplar")
+	}
+	labels, err := c.readLabels()
+	if err != nil {
+		return nil, err
+	}
+	rest := c.text[c.position:]
+	if rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return nil, c.error("Expected value of exemplar")
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, c.error("Expected value and optional timestamp of exemplar")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, c.error("Invalid value of exemplar " + fields[0])
+	}
+
+	exemplar := &PrometheusExemplar{Labels: labels, Value: value}
+	if len(fields) == 2 {
+		exemplar.Timestamp, err = c.parseTimestamp(fields[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exemplar, nil
+}
+
+// Reads labels in braces
+func (c *prometheusTextParser) readLabels() (map[string]string, error) {
+	labels := make(map[string]string)
+	c.position++
+
+	for {
+		c.skipSpaces()
+		if c.position < len(c.text) && c.text[c.position] == '}' {
+			c.position++
+			return labels, nil
+		}
+
+		name := c.readName(false)
+		if name == "" {
+			return nil, c.error("Expected label name")
+		}
+		if _, ok := labels[name]; ok {
+			return nil, c.error("Duplicate label " + name)
+		}
+
+		c.skipSpaces()
+		if c.position >= len(c.text) || c.text[c.position] != '=' {
+			return nil, c.error("Expected = after label " + name)
+		}
+		c.position++
+		c.skipSpaces()
+
+		value, err := c.readString()
+		if err != nil {
+			return nil, err
+		}
+		labels[name] = value
+
+		c.skipSpaces()
+		if c.position < len(c.text) && c.text[c.position] == ',' {
+			c.position++
+			continue
+		}
+		if c.position < len(c.text) && c.text[c.position] == '}' {
+			c.position++
+			return labels, nil
+		}
+		return nil, c.error("Expected , or } after label " + name)
+	}
+}
+
+func (c *prometheusTextParser) skipSpaces() {
+	for c.position < len(c.text) && (c.text[c.position] == ' ' || c.text[c.position] == '\t') {
+		c.position++
+	}
+}
+
+// Reads a word until a space
+func (c *prometheusTextParser) readToken() string {
+	start := c.position
+	for c.position < len(c.text) && c.text[c.position] != ' ' && c.text[c.position] != '\t' {
+		c.position++
+	}
+	return c.text[start:c.position]
+}
+
+// Reads metric name (with colons) or label name
+func (c *prometheusTextParser) readName(metric bool) string {
+	start := c.position
+	for c.position < len(c.text) {
+		ch := c.text[c.position]
+		isLetter := ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (metric && ch == ':')
+		isDigit := ch >= '0' && ch <= '9'
+		if !isLetter && !(isDigit && c.position > start) {
+			break
+		}
+		c.position++
+	}
+	return c.text[start:c.position]
+}
+
+// Reads a label value in double quotes with escapes
+func (c *prometheusTextParser) readString() (string, error) {
+	if c.position >= len(c.text) || c.text[c.position] != '"' {
+		return "", c.error("Expected quoted label value")
+	}
+	c.position++
+
+	var builder strings.Builder
+	for c.position < len(c.text) {
+		ch := c.text[c.position]
+		c.position++
+
+		if ch == '"' {
+			return builder.String(), nil
+		}
+		if ch == '\\' && c.position < len(c.text) {
+			escaped := c.text[c.position]
+			c.position++
+			switch escaped {
+			case 'n':
+				builder.WriteByte('\n')
+			case '\\', '"':
+				builder.WriteByte(escaped)
+			default:
+				return "", c.error("Invalid escape in label value")
+			}
+			continue
+		}
+		builder.WriteByte(ch)
+	}
+
+	return "", c.error("Unterminated label value")
+}
+
+func (c *prometheusTextParser) unescapeHelp(help string) string {
+	if !strings.Contains(help, `\`) {
+		return help
+	}
+
+	var builder strings.Builder
+	for index := 0; index < len(help); index++ {
+		ch := help[index]
+		if ch == '\\' && index+1 < len(help) {
+			switch help[index+1] {
+			case 'n':
+				builder.WriteByte('\n')
+				index++
+				continue
+			case '\\':
+				builder.WriteByte('\\')
+				index++
+				continue
+			case '"':
+				if c.openMetrics {
+					builder.WriteByte('"')
+					index++
+					continue
+				}
+			}
+		}
+		builder.WriteByte(ch)
+	}
+	return builder.String()
+}
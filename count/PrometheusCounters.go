@@ -2,8 +2,12 @@ package count
 
 import (
 	"bytes"
+	"compress/gzip"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
@@ -21,6 +25,11 @@ PrometheusCounters performance counters that send their metrics to Prometheus se
 The component is normally used in passive mode conjunction with PrometheusMetricsService.
 Alternatively when connection parameters are set it can push metrics to Prometheus PushGateway.
 
+Besides counters the component exposes metrics from its Registry. When pushing it also records
+its own metrics: prometheus_counters_push_attempts_total, prometheus_counters_push_failures_total (by reason),
+prometheus_counters_push_last_success_timestamp_seconds, prometheus_counters_push_duration_seconds,
+prometheus_counters_push_payload_bytes_total and prometheus_counters_push_queue_depth.
+
 Configuration parameters:
 
   - connection(s):
@@ -33,12 +42,57 @@ Configuration parameters:
     - retries:               number of retries (default: 3)
     - connect_timeout:       connection timeout in milliseconds (default: 10 sec)
     - timeout:               invocation timeout in milliseconds (default: 10 sec)
+    - other transport settings (keep-alive, idle connections, proxy, HTTP/2): see PrometheusTransportOptions
+    - timing_histograms:     record timings as histograms with exemplars in the registry (default: true)
+  - push:
+    - compression:           compression of pushed metrics: none or gzip (default: none)
+    - compression_min_size:  minimum body size in bytes to compress, smaller bodies are sent uncompressed (default: 1024)
+    - method:                HTTP method to push metrics: PUT replaces all metrics of the group, POST only metrics with the same names (default: PUT)
+    - delta:                 push only metric families changed since the last successful push using POST, all of them with PUT when a family disappears (default: false)
+    - resync_interval:       interval in milliseconds to push all metrics with PUT in delta mode, 0 to do it only once (default: 10 min)
+    - max_body_size:         maximum size in bytes of uncompressed request body, larger pushes are split into several requests, 0 for no limit (default: 0)
+    - mode:                  always to push on every save or fallback to push only when metrics aren't scraped (default: always)
+    - scrape_window:         time in milliseconds without scrapes to start pushing in fallback mode (default: 60 sec)
+  - textfile:
+    - path:                  (optional) path of .prom file for node_exporter textfile collector with {source} and {instance} placeholders
+    - remove_on_close:       remove the file when the component is closed (default: false)
+  - stale:
+    - ttl:                   time in milliseconds after which a series that isn't updated gets stale, 0 to keep series forever (default: 0)
+    - mode:                  what to do with stale series: mark to omit them from output or remove to delete them (default: mark)
+    - metrics:               TTLs in milliseconds for individual counters or registry metrics, names ending with * match prefixes
+
+Counters that stopped updating are detected by comparing their values between collections,
+so TTL of a counter is counted from the first collection where its value changed. Stale counters
+are omitted from GetAll results, scrapes and pushes. In remove mode they are also cleared from the cache,
+so a counter recorded again starts from scratch. Registry series get stale when they aren't written within TTL.
+Pushed groups keep stale series until the next push with PUT method.
+
+When textfile path is set, every save atomically replaces the file with the current metrics,
+so batch jobs and cron tasks can expose their metrics through node_exporter. The file is written
+independently from pushing and doesn't need a connection.
+
+In fallback push mode the component cooperates with PrometheusMetricsService that calls NotifyScrape
+on every scrape. When no scrapes arrive within the scrape window, pushing starts automatically.
+When scrapes resume, pushing stops and the pushed group is deleted from PushGateway, so its frozen
+values don't duplicate scraped metrics. Failed deletion is retried on next saves. Both transitions are logged.
+
+Timings are also recorded in the registry as histograms named after the counter with _seconds suffix,
+for instance exec_time_seconds with service and command labels.
+
+Measurements recorded by BeginTimingWithCorrelation and IncrementWithCorrelation keep the last
+correlation id of each counter as an exemplar with trace_id label. Exemplars are exposed only in OpenMetrics format,
+so a dashboard can jump from a metric to logs of the request that produced it. OpenMetrics allows exemplars
+only on counters and histogram buckets, so exemplars of timings are attached to buckets of their histograms.
 
 References:
 
 - *:logger:*:*:1.0         (optional) ILogger components to pass log messages
 - *:counters:*:*:1.0         (optional) ICounters components to pass collected measurements
 - *:discovery:*:*:1.0        (optional)  IDiscovery services to resolve connection
+- *:metrics-collector:*:*:1.0    (optional)  ICollector components which metrics are pushed together with the counters
+
+Collectors referenced as *:metrics-relay:*:*:1.0, like PrometheusScrapeCollector, are not pushed:
+they relay metrics of other processes and are exposed only by metrics services.
 
 See:  RestService
 See:  CommandableHttpService
@@ -59,6 +113,10 @@ Example:
         ...
     timing.EndTiming();
 
+    timing = counters.BeginTimingWithCorrelation("123", "mycomponent.mymethod.exec_time");
+        ...
+    timing.EndTiming();
+
     counters.Dump();
 */
 type PrometheusCounters struct {
@@ -72,8 +130,42 @@ type PrometheusCounters struct {
 	requestRoute       string
 	timeout            int
 	retries            int
-	connectTimeout     int
+	transportOptions   *PrometheusTransportOptions
 	uri                string
+	compression        string
+	compressionMinSize int
+	pushMethod         string
+	pushDelta          bool
+	resyncInterval     int64
+	pushTracker        *prometheusPushTracker
+	maxBodySize        int
+	timingHistograms   bool
+	registry           *PrometheusMetricsRegistry
+	pushMetrics        *PrometheusMetricsRegistry
+	collectors         []ICollector
+	staleTracker       *prometheusStaleTracker
+	staleMode          string
+	scrapeWatchdog     *prometheusScrapeWatchdog
+	textfile           *prometheusTextfileWriter
+	exemplars          map[string]*PrometheusExemplar
+	exemplarsLock      sync.Mutex
+	lock               sync.Mutex
+}
+
+// Names of metrics that PrometheusCounters records about its own pushes
+const (
+	pushAttemptsMetric     = "prometheus_counters_push_attempts_total"
+	pushFailuresMetric     = "prometheus_counters_push_failures_total"
+	pushLastSuccessMetric  = "prometheus_counters_push_last_success_timestamp_seconds"
+	pushDurationMetric     = "prometheus_counters_push_duration_seconds"
+	pushPayloadBytesMetric = "prometheus_counters_push_payload_bytes_total"
+	pushQueueDepthMetric   = "prometheus_counters_push_queue_depth"
+)
+
+// Part of metrics pushed in a single request
+type prometheusPushBatch struct {
+	families []*PrometheusMetricFamily
+	body     []byte
 }
 
 // NewPrometheusCounters is creates a new instance of the performance counters.
@@ -87,7 +179,20 @@ func NewPrometheusCounters() *PrometheusCounters {
 	c.opened = false
 	c.timeout = 10000
 	c.retries = 3
-	c.connectTimeout = 10000
+	c.transportOptions = NewPrometheusTransportOptions()
+	c.compression = "none"
+	c.compressionMinSize = 1024
+	c.pushMethod = http.MethodPut
+	c.resyncInterval = 600000
+	c.pushTracker = newPrometheusPushTracker()
+	c.registry = NewPrometheusMetricsRegistry()
+	c.pushMetrics = NewPrometheusMetricsRegistry()
+	c.staleTracker = newPrometheusStaleTracker()
+	c.staleMode = PrometheusStaleMark
+	c.scrapeWatchdog = newPrometheusScrapeWatchdog()
+	c.textfile = newPrometheusTextfileWriter()
+	c.exemplars = make(map[string]*PrometheusExemplar)
+	c.timingHistograms = true
 	return &c
 }
 
@@ -102,8 +207,19 @@ func (c *PrometheusCounters) Configure(config *cconf.ConfigParams) {
 	c.source = config.GetAsStringWithDefault("source", c.source)
 	c.instance = config.GetAsStringWithDefault("instance", c.instance)
 	c.retries = config.GetAsIntegerWithDefault("options.retries", c.retries)
-	c.connectTimeout = config.GetAsIntegerWithDefault("options.connectTimeout", c.connectTimeout)
+	c.transportOptions.Configure(config)
 	c.timeout = config.GetAsIntegerWithDefault("options.timeout", c.timeout)
+	c.timingHistograms = config.GetAsBooleanWithDefault("options.timing_histograms", c.timingHistograms)
+	c.compression = strings.ToLower(config.GetAsStringWithDefault("push.compression", c.compression))
+	c.compressionMinSize = config.GetAsIntegerWithDefault("push.compression_min_size", c.compressionMinSize)
+	c.pushMethod = strings.ToUpper(config.GetAsStringWithDefault("push.method", c.pushMethod))
+	c.pushDelta = config.GetAsBooleanWithDefault("push.delta", c.pushDelta)
+	c.resyncInterval = config.GetAsLongWithDefault("push.resync_interval", c.resyncInterval)
+	c.maxBodySize = config.GetAsIntegerWithDefault("push.max_body_size", c.maxBodySize)
+	c.staleTracker.Configure(config)
+	c.staleMode = strings.ToLower(config.GetAsStringWithDefault("stale.mode", c.staleMode))
+	c.scrapeWatchdog.Configure(config)
+	c.textfile.Configure(config)
 }
 
 // SetReferences method are sets references to dependent components.
@@ -121,6 +237,14 @@ func (c *PrometheusCounters) SetReferences(references cref.IReferences) {
 	if contextInfo != nil && c.instance == "" {
 		c.instance = contextInfo.ContextId
 	}
+
+	c.collectors = make([]ICollector, 0)
+	refs := references.GetOptional(cref.NewDescriptor("*", "metrics-collector", "*", "*", "1.0"))
+	for _, ref := range refs {
+		if collector, ok := ref.(ICollector); ok {
+			c.collectors = append(c.collectors, collector)
+		}
+	}
 }
 
 // IsOpen method are checks if the component is opened.
@@ -129,22 +253,191 @@ func (c *PrometheusCounters) IsOpen() bool {
 	return c.opened
 }
 
+// Registry method returns the registry of labeled metrics and histograms
+// that are exposed and pushed together with the counters.
+// Returns *PrometheusMetricsRegistry
+func (c *PrometheusCounters) Registry() *PrometheusMetricsRegistry {
+	return c.registry
+}
+
+// RegistryFamilies method returns metric families from the registry without stale series
+// together with metrics about pushes made by this component.
+// Returns []*PrometheusMetricFamily
+func (c *PrometheusCounters) RegistryFamilies() []*PrometheusMetricFamily {
+	families := c.freshRegistryFamilies()
+	return append(families, c.pushMetrics.Families()...)
+}
+
+// GetAll method returns all counters except stale ones.
+// In remove mode stale counters are also cleared from the cache.
+// Returns []*ccount.Counter
+func (c *PrometheusCounters) GetAll() []*ccount.Counter {
+	return c.freshCounters(c.CachedCounters.GetAll())
+}
+
+// NotifyScrape method tells the component that its metrics were scraped by Prometheus.
+// In fallback push mode it postpones pushing for the scrape window.
+func (c *PrometheusCounters) NotifyScrape() {
+	c.scrapeWatchdog.NotifyScrape()
+}
+
+// BeginTiming method starts measurement of execution time interval.
+// When the timing ends, the elapsed time is recorded in the counter and in the timing histogram.
+//   - name             a counter name of Interval type.
+// Returns *ccount.CounterTiming
+// callback object that shall be called to end timing
+func (c *PrometheusCounters) BeginTiming(name string) *ccount.CounterTiming {
+	return ccount.NewCounterTiming(name, &prometheusTimingCallback{counters: c})
+}
+
+// BeginTimingWithCorrelation method starts measurement of execution time interval like BeginTiming.
+// When the timing ends, the correlation id and the elapsed time are kept as the counter's exemplar
+// and as the exemplar of the timing histogram bucket.
+//   - correlationId    (optional) transaction id to trace execution through call chain.
+//   - name             a counter name of Interval type.
+// Returns *ccount.CounterTiming
+// callback object that shall be called to end timing
+func (c *PrometheusCounters) BeginTimingWithCorrelation(correlationId string, name string) *ccount.CounterTiming {
+	return ccount.NewCounterTiming(name, &prometheusTimingCallback{counters: c, correlationId: correlationId})
+}
+
+// IncrementWithCorrelation method increments counter by given value like Increment
+// and keeps the correlation id as the counter's exemplar.
+//   - correlationId    (optional) transaction id to trace execution through call chain.
+//   - name             a counter name of Increment type.
+//   - value            a value to add to the counter.
+func (c *PrometheusCounters) IncrementWithCorrelation(correlationId string, name string, value int) {
+	c.Increment(name, value)
+	c.setExemplar(correlationId, name, float64(value))
+}
+
+// Exemplars method returns the last exemplars of counters by their names.
+// Returns map[string]*PrometheusExemplar
+func (c *PrometheusCounters) Exemplars() map[string]*PrometheusExemplar {
+	c.exemplarsLock.Lock()
+	defer c.exemplarsLock.Unlock()
+
+	result := make(map[string]*PrometheusExemplar, len(c.exemplars))
+	for name, exemplar := range c.exemplars {
+		result[name] = exemplar
+	}
+	return result
+}
+
+func (c *PrometheusCounters) setExemplar(correlationId string, name string, value float64) {
+	if correlationId == "" {
+		return
+	}
+
+	c.exemplarsLock.Lock()
+	defer c.exemplarsLock.Unlock()
+	c.exemplars[name] = NewPrometheusExemplar(correlationId, value)
+}
+
+// Records elapsed time in milliseconds in the timing histogram in seconds
+func (c *PrometheusCounters) observeTiming(correlationId string, name string, elapsed float32) {
+	if !c.timingHistograms {
+		return
+	}
+
+	histogramName, labels := PrometheusCounterConverter.timingHistogram(name)
+	seconds := PrometheusCounterConverter.toFloat64(elapsed) / 1000
+	var exemplar *PrometheusExemplar
+	if correlationId != "" {
+		exemplar = NewPrometheusExemplar(correlationId, seconds)
+	}
+	c.registry.ObserveHistogramWithExemplar(histogramName, "Duration of measured timings in seconds",
+		nil, labels, seconds, exemplar)
+}
+
+func (c *PrometheusCounters) removeExemplar(name string) {
+	c.exemplarsLock.Lock()
+	defer c.exemplarsLock.Unlock()
+	delete(c.exemplars, name)
+}
+
+// Filters out stale counters and removes them in remove mode
+func (c *PrometheusCounters) freshCounters(counters []*ccount.Counter) []*ccount.Counter {
+	if !c.staleTracker.Enabled() {
+		return counters
+	}
+
+	fresh, stale := c.staleTracker.Split(counters)
+	if c.staleMode == PrometheusStaleRemove {
+		for _, counter := range stale {
+			c.CachedCounters.Clear(counter.Name)
+			c.staleTracker.Forget(counter.Name)
+			c.removeExemplar(counter.Name)
+		}
+	}
+	return fresh
+}
+
+// Returns registry families without stale series and removes them in remove mode
+func (c *PrometheusCounters) freshRegistryFamilies() []*PrometheusMetricFamily {
+	if !c.staleTracker.Enabled() {
+		return c.registry.Families()
+	}
+
+	if c.staleMode == PrometheusStaleRemove {
+		c.registry.RemoveStale(c.staleTracker.Ttl)
+	}
+	return c.registry.FreshFamilies(c.staleTracker.Ttl)
+}
+
 // Open method are opens the component.
 // - correlationId 	string
 // (optional) transaction id to trace execution through call chain.
 // Returns error
 //	error or nil, if no errors occured.
 func (c *PrometheusCounters) Open(correlationId string) (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	if c.opened {
 		return nil
 	}
 
+	if c.compression != "none" && c.compression != "gzip" {
+		return cerr.NewConfigError(correlationId, "UNSUPPORTED_COMPRESSION", "Compression "+c.compression+" is not supported").
+			WithDetails("compression", c.compression)
+	}
+
+	if c.pushMethod != http.MethodPut && c.pushMethod != http.MethodPost {
+		return cerr.NewConfigError(correlationId, "UNSUPPORTED_METHOD", "Push method "+c.pushMethod+" is not supported").
+			WithDetails("method", c.pushMethod)
+	}
+
+	if c.staleMode != PrometheusStaleMark && c.staleMode != PrometheusStaleRemove {
+		return cerr.NewConfigError(correlationId, "UNSUPPORTED_STALE_MODE", "Stale mode "+c.staleMode+" is not supported").
+			WithDetails("mode", c.staleMode)
+	}
+
+	pushMode := c.scrapeWatchdog.Mode()
+	if pushMode != PrometheusPushAlways && pushMode != PrometheusPushFallback {
+		return cerr.NewConfigError(correlationId, "UNSUPPORTED_PUSH_MODE", "Push mode "+pushMode+" is not supported").
+			WithDetails("mode", pushMode)
+	}
+
+	instance := c.instance
+	if instance == "" {
+		host, _ := os.Hostname()
+		instance = host
+	}
+
+	err = c.textfile.Open(correlationId, c.source, instance)
+	if err != nil {
+		return err
+	}
+
 	c.opened = true
 	connection, _, err := c.connectionResolver.Resolve(correlationId)
 
 	if err != nil {
 		c.client = nil
-		c.logger.Warn(correlationId, "Connection to Prometheus server is not configured: "+err.Error())
+		if !c.textfile.Enabled() {
+			c.logger.Warn(correlationId, "Connection to Prometheus server is not configured: "+err.Error())
+		}
 		return nil
 	}
 
@@ -155,20 +448,19 @@ func (c *PrometheusCounters) Open(correlationId string) (err error) {
 		job = "unknown"
 	}
 
-	instance := c.instance
-	if instance == "" {
-		host, _ := os.Hostname()
-		instance = host
-	}
 	c.requestRoute = "/metrics/job/" + job + "/instance/" + instance
 
+	transport, err := c.transportOptions.CreateTransport(correlationId)
+	if err != nil {
+		c.opened = false
+		return err
+	}
+
 	localClient := http.Client{}
 	localClient.Timeout = (time.Duration)(c.timeout) * time.Millisecond
+	localClient.Transport = transport
 	c.client = &localClient
-	if c.client == nil {
-		ex := cerr.NewConnectionError(correlationId, "CANNOT_CONNECT", "Connection to REST service failed").WithDetails("url", c.uri)
-		return ex
-	}
+	c.scrapeWatchdog.Reset()
 
 	return nil
 }
@@ -180,10 +472,17 @@ func (c *PrometheusCounters) Open(correlationId string) (err error) {
 // Returns error
 // error or nil, if no errors occured.
 func (c *PrometheusCounters) Close(correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	c.opened = false
+	if c.client != nil {
+		c.client.CloseIdleConnections()
+	}
 	c.client = nil
 	c.requestRoute = ""
-	return nil
+	c.pushTracker.Reset()
+	return c.textfile.Close(correlationId)
 }
 
 // Save method are saves the current counters measurements.
@@ -192,31 +491,228 @@ func (c *PrometheusCounters) Close(correlationId string) error {
 // Retruns error
 // error or nil, if no errors occured.
 func (c *PrometheusCounters) Save(counters []*ccount.Counter) (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client == nil && !c.textfile.Enabled() {
+		return nil
+	}
+
+	families := PrometheusCounterConverter.ToMetricFamilies(c.freshCounters(counters), "", "")
+	families = append(families, c.freshRegistryFamilies()...)
+	families = append(families, CollectMetricFamilies(c.collectors)...)
+
+	textErr := c.textfile.Write("prometheus-counters", families)
+	if textErr != nil {
+		c.logger.Error("prometheus-counters", textErr, "Failed to write metrics to textfile")
+	}
+
+	pushErr := c.pushFamilies(families)
+	if textErr != nil {
+		return textErr
+	}
+	return pushErr
+}
+
+// Pushes metric families to PushGateway according to push mode and delta settings.
+//   - families  metric families to push.
+// Returns error or nil, if no errors occured.
+func (c *PrometheusCounters) pushFamilies(families []*PrometheusMetricFamily) error {
 	if c.client == nil {
 		return nil
 	}
 
+	push, changed := c.scrapeWatchdog.ShouldPush()
+	if changed && push {
+		c.logger.Info("prometheus-counters", "No scrapes within the scrape window, started pushing metrics to %s", c.uri)
+		// Pushes resume with the whole group
+		c.pushTracker.Reset()
+	}
+	if changed && !push {
+		c.logger.Info("prometheus-counters", "Scrapes resumed, stopped pushing metrics to %s", c.uri)
+	}
+	if !push {
+		// Deletion is retried on next saves, so frozen values don't stay in PushGateway
+		if !c.scrapeWatchdog.IsDeletePending() {
+			return nil
+		}
+		err := c.push(http.MethodDelete, nil)
+		if err == nil {
+			c.scrapeWatchdog.Deleted()
+		}
+		return err
+	}
+
+	method := c.pushMethod
+	full := true
+	if c.pushDelta {
+		// Periodic full push with PUT replaces the whole group and corrects any drift.
+		// It is also required to delete families that are not collected anymore
+		full = c.pushTracker.IsFullPushDue(c.resyncInterval) || c.pushTracker.HasRemoved(families)
+		if full {
+			method = http.MethodPut
+		} else {
+			method = http.MethodPost
+			families = c.pushTracker.Changed(families)
+			if len(families) == 0 {
+				return nil
+			}
+		}
+	}
+
+	// Metrics about pushes change every time, so they are not tracked and go with every push
+	tracked := make(map[string]bool, len(families))
+	for _, family := range families {
+		tracked[family.Name] = true
+	}
+	families = append(families, c.pushMetrics.Families()...)
+
+	batches := c.splitIntoBatches(families)
+	errs := make([]error, 0)
+	sent := 0
+	pushed := make([]*PrometheusMetricFamily, 0)
+
+	for index, batch := range batches {
+		c.pushMetrics.SetGauge(pushQueueDepthMetric, "Number of metric batches waiting to be pushed",
+			nil, float64(len(batches)-index))
+
+		// Only the first batch may replace the whole group, others are added to it
+		batchMethod := method
+		if index > 0 {
+			batchMethod = http.MethodPost
+		}
+
+		pushErr := c.push(batchMethod, batch.body)
+		if pushErr != nil {
+			errs = append(errs, pushErr)
+			// Other batches would be merged into the stale group, so they are not sent
+			if index == 0 && batchMethod == http.MethodPut {
+				break
+			}
+			continue
+		}
+		sent++
+		for _, family := range batch.families {
+			if tracked[family.Name] {
+				pushed = append(pushed, family)
+			}
+		}
+	}
+
+	c.pushMetrics.SetGauge(pushQueueDepthMetric, "Number of metric batches waiting to be pushed", nil, 0)
+
+	if c.pushDelta {
+		// Failed full push is repeated next time
+		c.pushTracker.Update(pushed, full && len(errs) == 0)
+	}
+
+	return c.composePushError(errs, len(batches)-sent, len(batches))
+}
+
+// Splits metric families into batches with encoded size under push.max_body_size.
+// A family is never split between batches, so a family larger than the limit is sent alone.
+//   - families  metric families to push.
+// Returns batches with encoded bodies. There is always at least one batch.
+func (c *PrometheusCounters) splitIntoBatches(families []*PrometheusMetricFamily) []*prometheusPushBatch {
+	batch := &prometheusPushBatch{families: make([]*PrometheusMetricFamily, 0)}
+	result := []*prometheusPushBatch{batch}
+
+	for _, family := range families {
+		text := PrometheusCounterConverter.FamiliesToString([]*PrometheusMetricFamily{family})
+
+		if c.maxBodySize > 0 && len(batch.body) > 0 && len(batch.body)+len(text) > c.maxBodySize {
+			batch = &prometheusPushBatch{families: make([]*PrometheusMetricFamily, 0)}
+			result = append(result, batch)
+		}
+
+		batch.families = append(batch.families, family)
+		batch.body = append(batch.body, text...)
+	}
+
+	return result
+}
+
+// Combines errors of failed batches into a single error.
+//   - errs      errors of failed batches.
+//   - failed    number of batches that were not pushed, including the skipped ones.
+//   - total     total number of batches.
+// Returns error or nil, if all batches were pushed.
+func (c *PrometheusCounters) composePushError(errs []error, failed int, total int) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	if total == 1 {
+		return errs[0]
+	}
+
+	messages := make([]string, len(errs))
+	for index, err := range errs {
+		messages[index] = err.Error()
+	}
+
+	return cerr.NewUnknownError("PrometheusCounters", "PUSH_FAILED",
+		"Failed to push "+strconv.Itoa(failed)+" of "+strconv.Itoa(total)+" metric batches").
+		WithDetails("errors", messages).
+		WithCause(errs[0])
+}
+
+// Sends metrics in text exposition format to Prometheus PushGateway.
+//   - method    HTTP method: PUT replaces all metrics in the group, POST only the ones with the same names, DELETE removes the group
+//   - body      metrics in text exposition format
+// Returns error or nil, if no errors occured.
+func (c *PrometheusCounters) push(method string, body []byte) (err error) {
 	url := c.uri + c.requestRoute
-	body := PrometheusCounterConverter.ToString(counters, "", "")
 
-	req, reqErr := http.NewRequest(http.MethodPut, url, bytes.NewBuffer([]byte(body)))
-	if reqErr != nil {
-		err = cerr.NewUnknownError("PrometheusCounters", "UNSUPPORTED_METHOD", "Method is not supported by REST client").WithDetails("verb", "PUT").WithCause(reqErr)
+	start := time.Now()
+	reason := ""
+	defer func() {
+		c.pushMetrics.ObserveHistogram(pushDurationMetric, "Duration of metric pushes in seconds", nil, nil, time.Since(start).Seconds())
+		if err != nil {
+			c.pushMetrics.AddCounter(pushFailuresMetric, "Total number of failed metric pushes by reason",
+				map[string]string{"reason": reason}, 1)
+		} else {
+			c.pushMetrics.SetGauge(pushLastSuccessMetric, "Time of the last successful metric push in unix seconds",
+				nil, float64(time.Now().UnixNano())/1e9)
+		}
+	}()
+
+	body, encoding, err := c.compress(body)
+	if err != nil {
+		reason = "compression"
 		return err
 	}
-	// Set headers
-	req.Header.Set("Accept", "text/html")
+	c.pushMetrics.AddCounter(pushPayloadBytesMetric, "Total number of pushed bytes", nil, float64(len(body)))
+
 	retries := c.retries
+	if retries < 1 {
+		retries = 1
+	}
 	var resp *http.Response
 	var respErr error
 
 	for retries > 0 {
-		// Try send request
+		// The request is recreated on every attempt because a sent body cannot be read again
+		req, reqErr := http.NewRequest(method, url, bytes.NewReader(body))
+		if reqErr != nil {
+			reason = "request"
+			err = cerr.NewUnknownError("PrometheusCounters", "UNSUPPORTED_METHOD", "Method is not supported by REST client").WithDetails("verb", method).WithCause(reqErr)
+			return err
+		}
+		// Set headers
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+
+		// Try send request, every retry is counted as a separate attempt
+		c.pushMetrics.AddCounter(pushAttemptsMetric, "Total number of attempts to push metrics", nil, 1)
 		resp, respErr = c.client.Do(req)
 		if respErr != nil {
 
 			retries--
 			if retries == 0 {
+				reason = "connection"
 				err = cerr.NewUnknownError("PrometheusCounters", "COMMUNICATION_ERROR", "Unknown communication problem on REST client").WithCause(respErr)
 				return err
 			}
@@ -225,13 +721,48 @@ func (c *PrometheusCounters) Save(counters []*ccount.Counter) (err error) {
 		}
 		break
 	}
-	if resp != nil {
-		defer resp.Body.Close()
-		if resp.StatusCode >= 204 && resp.StatusCode < 300 {
-			return nil
-		}
-		c.logger.Error("prometheus-counters", respErr, "Failed to push metrics to prometheus")
+
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	reason = "http_" + strconv.Itoa(resp.StatusCode/100) + "xx"
+	err = cerr.NewUnknownError("PrometheusCounters", "PUSH_FAILED", "Failed to push metrics to prometheus").
+		WithDetails("status", resp.StatusCode)
+	c.logger.Error("prometheus-counters", err, "Failed to push metrics to prometheus")
+	return err
+}
+
+// Compresses request body when compression is enabled and the body is large enough.
+//   - body      uncompressed request body
+// Returns compressed body, value for Content-Encoding header (empty when not compressed) and error.
+func (c *PrometheusCounters) compress(body []byte) ([]byte, string, error) {
+	if c.compression == "" || c.compression == "none" || len(body) < c.compressionMinSize {
+		return body, "", nil
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write(body)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, "", cerr.NewUnknownError("PrometheusCounters", "COMPRESSION_FAILED", "Failed to compress metrics").WithCause(err)
 	}
 
-	return respErr
+	return buffer.Bytes(), "gzip", nil
+}
+
+// Ends timings started by BeginTimingWithCorrelation
+type prometheusTimingCallback struct {
+	counters      *PrometheusCounters
+	correlationId string
+}
+
+func (c *prometheusTimingCallback) EndTiming(name string, elapsed float32) {
+	c.counters.EndTiming(name, elapsed)
+	c.counters.setExemplar(c.correlationId, name, PrometheusCounterConverter.toFloat64(elapsed))
+	c.counters.observeTiming(c.correlationId, name, elapsed)
 }
@@ -0,0 +1,132 @@
+package count
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Types of Prometheus metric families
+const (
+	PrometheusGauge     = "gauge"
+	PrometheusCounter   = "counter"
+	PrometheusHistogram = "histogram"
+	PrometheusSummary   = "summary"
+	PrometheusUntyped   = "untyped"
+)
+
+// PrometheusSample is a single value of a time series in a Prometheus metric family.
+// The sample name may differ from the family name by a suffix such as _bucket, _sum or _count.
+type PrometheusSample struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+	// Exemplar is written only in OpenMetrics format
+	Exemplar *PrometheusExemplar
+}
+
+// PrometheusExemplar links a sample to an individual event, like a request with its correlation id.
+// Timestamp is in milliseconds since epoch, 0 when unknown.
+type PrometheusExemplar struct {
+	Labels    map[string]string
+	Value     float64
+	Timestamp int64
+}
+
+// NewPrometheusExemplar creates a new exemplar with trace_id label recorded at the current time.
+//   - traceId    a trace or correlation id.
+//   - value      a value of the event.
+// Returns *PrometheusExemplar
+// pointer on new instance
+func NewPrometheusExemplar(traceId string, value float64) *PrometheusExemplar {
+	return &PrometheusExemplar{
+		Labels:    map[string]string{"trace_id": traceId},
+		Value:     value,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// PrometheusMetricFamily is a group of samples that share the same metric name, type and help text.
+type PrometheusMetricFamily struct {
+	Name    string
+	Type    string
+	Help    string
+	Samples []*PrometheusSample
+}
+
+// NewPrometheusMetricFamily creates a new empty metric family.
+//   - name      a metric name.
+//   - typ       a metric type: gauge, counter, histogram, summary or untyped.
+//   - help      (optional) a help text.
+// Returns *PrometheusMetricFamily
+// pointer on new instance
+func NewPrometheusMetricFamily(name string, typ string, help string) *PrometheusMetricFamily {
+	return &PrometheusMetricFamily{
+		Name:    name,
+		Type:    typ,
+		Help:    help,
+		Samples: make([]*PrometheusSample, 0),
+	}
+}
+
+// AddSample method adds a new sample to the family.
+//   - name      a sample name, usually the family name with an optional suffix.
+//   - labels    sample labels, can be nil.
+//   - value     a sample value.
+// Returns *PrometheusSample
+// the added sample
+func (c *PrometheusMetricFamily) AddSample(name string, labels map[string]string, value float64) *PrometheusSample {
+	sample := &PrometheusSample{
+		Name:   name,
+		Labels: labels,
+		Value:  value,
+	}
+	c.Samples = append(c.Samples, sample)
+	return sample
+}
+
+// Key method returns a unique identity of the sample time series
+// composed from the sample name and its labels sorted by names.
+func (c *PrometheusSample) Key() string {
+	if len(c.Labels) == 0 {
+		return c.Name
+	}
+
+	var builder strings.Builder
+	builder.WriteString(c.Name)
+	builder.WriteString("{")
+	for index, name := range SortedLabelNames(c.Labels) {
+		if index > 0 {
+			builder.WriteString(",")
+		}
+		builder.WriteString(name)
+		builder.WriteString(`="`)
+		builder.WriteString(EscapeLabelValue(c.Labels[name]))
+		builder.WriteString(`"`)
+	}
+	builder.WriteString("}")
+	return builder.String()
+}
+
+// SortedLabelNames returns names of the given labels in alphabetical order.
+func SortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// EscapeLabelValue escapes backslashes, double quotes and line feeds in a label value
+// as required by Prometheus text exposition format.
+func EscapeLabelValue(value string) string {
+	if !strings.ContainsAny(value, "\\\"\n") {
+		return value
+	}
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return value
+}
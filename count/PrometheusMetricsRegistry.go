@@ -0,0 +1,336 @@
+package count
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PrometheusDefaultBuckets are default upper bounds of histogram buckets in seconds.
+var PrometheusDefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+/*
+PrometheusMetricsRegistry keeps labeled counters, gauges and histograms that can't be
+represented by regular ICounters, like metrics with arbitrary labels or latency histograms.
+
+The registry is safe for concurrent use. A metric type is defined by the first call for its name,
+later calls for the same name with a different type are ignored.
+
+Example:
+
+    registry := NewPrometheusMetricsRegistry()
+    registry.AddCounter("http_requests_total", "Total number of HTTP requests",
+        map[string]string{"method": "GET", "code": "200"}, 1)
+    registry.ObserveHistogram("http_request_duration_seconds", "Duration of HTTP requests",
+        PrometheusDefaultBuckets, map[string]string{"method": "GET"}, 0.042)
+    registry.ObserveHistogramWithExemplar("http_request_duration_seconds", "Duration of HTTP requests",
+        PrometheusDefaultBuckets, map[string]string{"method": "GET"}, 0.042, NewPrometheusExemplar("123", 0.042))
+
+    families := registry.Families()
+*/
+type PrometheusMetricsRegistry struct {
+	lock     sync.Mutex
+	families map[string]*registryFamily
+}
+
+type registryFamily struct {
+	name    string
+	typ     string
+	help    string
+	buckets []float64
+	series  map[string]*registrySeries
+}
+
+type registrySeries struct {
+	labels       map[string]string
+	value        float64
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+	updated      time.Time
+	exemplar     *PrometheusExemplar
+	// Exemplars of histogram buckets including +Inf bucket
+	bucketExemplars []*PrometheusExemplar
+}
+
+// NewPrometheusMetricsRegistry creates a new empty registry.
+// Returns *PrometheusMetricsRegistry
+// pointer on new instance
+func NewPrometheusMetricsRegistry() *PrometheusMetricsRegistry {
+	return &PrometheusMetricsRegistry{
+		families: make(map[string]*registryFamily),
+	}
+}
+
+// AddCounter method increases a counter by the given value.
+//   - name      a metric name, usually with _total suffix.
+//   - help      a help text.
+//   - labels    metric labels, can be nil.
+//   - value     a value to add, negative values are ignored.
+func (c *PrometheusMetricsRegistry) AddCounter(name string, help string, labels map[string]string, value float64) {
+	c.AddCounterWithExemplar(name, help, labels, value, nil)
+}
+
+// AddCounterWithExemplar method increases a counter like AddCounter and replaces its exemplar.
+//   - name      a metric name, usually with _total suffix.
+//   - help      a help text.
+//   - labels    metric labels, can be nil.
+//   - value     a value to add, negative values are ignored.
+//   - exemplar  (optional) an exemplar of the increment, for instance with a correlation id.
+func (c *PrometheusMetricsRegistry) AddCounterWithExemplar(name string, help string, labels map[string]string,
+	value float64, exemplar *PrometheusExemplar) {
+	if value < 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	series := c.getSeries(name, PrometheusCounter, help, nil, labels)
+	if series != nil {
+		series.value += value
+		if exemplar != nil {
+			series.exemplar = exemplar
+		}
+	}
+}
+
+// SetGauge method sets a gauge to the given value.
+//   - name      a metric name.
+//   - help      a help text.
+//   - labels    metric labels, can be nil.
+//   - value     a value to set.
+func (c *PrometheusMetricsRegistry) SetGauge(name string, help string, labels map[string]string, value float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	series := c.getSeries(name, PrometheusGauge, help, nil, labels)
+	if series != nil {
+		series.value = value
+	}
+}
+
+// AddGauge method increases or decreases a gauge by the given value.
+//   - name      a metric name.
+//   - help      a help text.
+//   - labels    metric labels, can be nil.
+//   - value     a value to add, can be negative.
+func (c *PrometheusMetricsRegistry) AddGauge(name string, help string, labels map[string]string, value float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	series := c.getSeries(name, PrometheusGauge, help, nil, labels)
+	if series != nil {
+		series.value += value
+	}
+}
+
+// ObserveHistogram method records an observation in a histogram.
+//   - name      a metric name.
+//   - help      a help text.
+//   - buckets   upper bounds of histogram buckets, PrometheusDefaultBuckets when nil.
+//               Buckets are fixed by the first observation.
+//   - labels    metric labels, can be nil.
+//   - value     an observed value.
+func (c *PrometheusMetricsRegistry) ObserveHistogram(name string, help string, buckets []float64,
+	labels map[string]string, value float64) {
+	c.ObserveHistogramWithExemplar(name, help, buckets, labels, value, nil)
+}
+
+// ObserveHistogramWithExemplar method records an observation like ObserveHistogram
+// and replaces exemplar of the bucket the value falls into.
+//   - name      a metric name.
+//   - help      a help text.
+//   - buckets   upper bounds of histogram buckets, PrometheusDefaultBuckets when nil.
+//   - labels    metric labels, can be nil.
+//   - value     an observed value.
+//   - exemplar  (optional) an exemplar of the observation, for instance with a correlation id.
+func (c *PrometheusMetricsRegistry) ObserveHistogramWithExemplar(name string, help string, buckets []float64,
+	labels map[string]string, value float64, exemplar *PrometheusExemplar) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if buckets == nil {
+		buckets = PrometheusDefaultBuckets
+	}
+
+	series := c.getSeries(name, PrometheusHistogram, help, buckets, labels)
+	if series == nil {
+		return
+	}
+
+	family := c.families[name]
+	exemplarIndex := len(family.buckets)
+	for index := len(family.buckets) - 1; index >= 0; index-- {
+		if value <= family.buckets[index] {
+			series.bucketCounts[index]++
+			exemplarIndex = index
+		}
+	}
+	series.sum += value
+	series.count++
+
+	if exemplar != nil {
+		series.bucketExemplars[exemplarIndex] = exemplar
+	}
+}
+
+// RemoveStale method removes series that were not updated longer than their TTL.
+//   - ttl    a function that returns TTL for a metric name, 0 to keep the metric forever.
+// Returns int
+// number of removed series
+func (c *PrometheusMetricsRegistry) RemoveStale(ttl func(name string) time.Duration) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for name, family := range c.families {
+		for key, series := range family.series {
+			if c.isStale(series, ttl(name), now) {
+				delete(family.series, key)
+				removed++
+			}
+		}
+		if len(family.series) == 0 {
+			delete(c.families, name)
+		}
+	}
+	return removed
+}
+
+// Families method returns a snapshot of all metrics as metric families sorted by names.
+// Returns []*PrometheusMetricFamily
+// metric families with copies of the current values
+func (c *PrometheusMetricsRegistry) Families() []*PrometheusMetricFamily {
+	return c.FreshFamilies(nil)
+}
+
+// FreshFamilies method returns a snapshot of metrics like Families, but only with series
+// updated within their TTL. Stale series are kept in the registry and appear again when updated.
+//   - ttl    a function that returns TTL for a metric name, 0 to keep the metric forever. nil returns all series.
+// Returns []*PrometheusMetricFamily
+// metric families with copies of the current values
+func (c *PrometheusMetricsRegistry) FreshFamilies(ttl func(name string) time.Duration) []*PrometheusMetricFamily {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	result := make([]*PrometheusMetricFamily, 0, len(c.families))
+
+	for _, family := range c.families {
+		metricFamily := NewPrometheusMetricFamily(family.name, family.typ, family.help)
+		familyTtl := time.Duration(0)
+		if ttl != nil {
+			familyTtl = ttl(family.name)
+		}
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := family.series[key]
+			if c.isStale(series, familyTtl, now) {
+				continue
+			}
+			if family.typ != PrometheusHistogram {
+				sample := metricFamily.AddSample(family.name, c.copyLabels(series.labels, "", ""), series.value)
+				sample.Exemplar = series.exemplar
+				continue
+			}
+
+			for index, bound := range family.buckets {
+				labels := c.copyLabels(series.labels, "le", FormatSampleValue(bound))
+				sample := metricFamily.AddSample(family.name+"_bucket", labels, float64(series.bucketCounts[index]))
+				sample.Exemplar = series.bucketExemplars[index]
+			}
+			labels := c.copyLabels(series.labels, "le", "+Inf")
+			sample := metricFamily.AddSample(family.name+"_bucket", labels, float64(series.count))
+			sample.Exemplar = series.bucketExemplars[len(family.buckets)]
+			metricFamily.AddSample(family.name+"_sum", c.copyLabels(series.labels, "", ""), series.sum)
+			metricFamily.AddSample(family.name+"_count", c.copyLabels(series.labels, "", ""), float64(series.count))
+		}
+
+		if len(metricFamily.Samples) > 0 {
+			result = append(result, metricFamily)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func (c *PrometheusMetricsRegistry) isStale(series *registrySeries, ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && now.Sub(series.updated) > ttl
+}
+
+// Finds or creates a series. Returns nil if the metric is already registered with another type.
+func (c *PrometheusMetricsRegistry) getSeries(name string, typ string, help string, buckets []float64,
+	labels map[string]string) *registrySeries {
+	family, ok := c.families[name]
+	if !ok {
+		family = &registryFamily{
+			name:   name,
+			typ:    typ,
+			help:   help,
+			series: make(map[string]*registrySeries),
+		}
+		if buckets != nil {
+			family.buckets = c.normalizeBuckets(buckets)
+		}
+		c.families[name] = family
+	}
+	if family.typ != typ {
+		return nil
+	}
+
+	key := (&PrometheusSample{Labels: labels}).Key()
+	series, ok := family.series[key]
+	if !ok {
+		series = &registrySeries{
+			labels: c.copyLabels(labels, "", ""),
+		}
+		if typ == PrometheusHistogram {
+			series.bucketCounts = make([]uint64, len(family.buckets))
+			series.bucketExemplars = make([]*PrometheusExemplar, len(family.buckets)+1)
+		}
+		family.series[key] = series
+	}
+	series.updated = time.Now()
+	return series
+}
+
+// Sorts bucket bounds and removes duplicates and +Inf, which is always added on output
+func (c *PrometheusMetricsRegistry) normalizeBuckets(buckets []float64) []float64 {
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	result := make([]float64, 0, len(sorted))
+	for _, bound := range sorted {
+		if math.IsInf(bound, 1) || math.IsNaN(bound) {
+			continue
+		}
+		if len(result) > 0 && result[len(result)-1] == bound {
+			continue
+		}
+		result = append(result, bound)
+	}
+	return result
+}
+
+// Copies labels optionally adding one more label
+func (c *PrometheusMetricsRegistry) copyLabels(labels map[string]string, name string, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for key, labelValue := range labels {
+		result[key] = labelValue
+	}
+	if name != "" {
+		result[name] = value
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package count
+
+import (
+	"math"
+	"time"
+)
+
+// prometheusPushTracker remembers values of series pushed to Prometheus PushGateway
+// to select metric families that changed since the last successful push.
+// Families are tracked as a whole because POST to PushGateway replaces
+// all series of a metric name, so a family can't be sent partially.
+type prometheusPushTracker struct {
+	families     map[string]map[string]float64
+	lastFullPush time.Time
+}
+
+func newPrometheusPushTracker() *prometheusPushTracker {
+	return &prometheusPushTracker{
+		families: make(map[string]map[string]float64),
+	}
+}
+
+// Checks if all metrics shall be pushed to correct a possible drift.
+//   - interval  resync interval in milliseconds, 0 to resync only on the first push.
+func (c *prometheusPushTracker) IsFullPushDue(interval int64) bool {
+	if c.lastFullPush.IsZero() {
+		return true
+	}
+	return interval > 0 && time.Since(c.lastFullPush) >= time.Duration(interval)*time.Millisecond
+}
+
+// Selects families that have new, removed or changed samples since the last push.
+func (c *prometheusPushTracker) Changed(families []*PrometheusMetricFamily) []*PrometheusMetricFamily {
+	result := make([]*PrometheusMetricFamily, 0)
+
+	for _, family := range families {
+		pushed, ok := c.families[family.Name]
+		if !ok || len(pushed) != len(family.Samples) {
+			result = append(result, family)
+			continue
+		}
+
+		for _, sample := range family.Samples {
+			value, ok := pushed[sample.Key()]
+			if !ok || !c.equal(value, sample.Value) {
+				result = append(result, family)
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// Checks if families pushed before are missing in the given ones.
+// POST can't remove series from PushGateway, so removed families require a full push.
+func (c *prometheusPushTracker) HasRemoved(families []*PrometheusMetricFamily) bool {
+	names := make(map[string]bool, len(families))
+	for _, family := range families {
+		names[family.Name] = true
+	}
+
+	for name := range c.families {
+		if !names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// Remembers successfully pushed families.
+//   - families  pushed families.
+//   - full      true if the push replaced all metrics in PushGateway group.
+func (c *prometheusPushTracker) Update(families []*PrometheusMetricFamily, full bool) {
+	if full {
+		c.families = make(map[string]map[string]float64)
+		c.lastFullPush = time.Now()
+	}
+
+	for _, family := range families {
+		pushed := make(map[string]float64, len(family.Samples))
+		for _, sample := range family.Samples {
+			pushed[sample.Key()] = sample.Value
+		}
+		c.families[family.Name] = pushed
+	}
+}
+
+// Clears all remembered state, so the next push is a full one.
+func (c *prometheusPushTracker) Reset() {
+	c.families = make(map[string]map[string]float64)
+	c.lastFullPush = time.Time{}
+}
+
+func (c *prometheusPushTracker) equal(value1 float64, value2 float64) bool {
+	return value1 == value2 || (math.IsNaN(value1) && math.IsNaN(value2))
+}
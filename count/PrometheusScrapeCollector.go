@@ -0,0 +1,486 @@
+package count
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
+	clog "github.com/pip-services3-go/pip-services3-components-go/log"
+)
+
+// Names of metrics about scraped targets
+const (
+	scrapeTargetUpMetric       = "prometheus_proxy_target_up"
+	scrapeTargetDurationMetric = "prometheus_proxy_scrape_duration_seconds"
+)
+
+/*
+PrometheusScrapeCollector is a collector that periodically scrapes other metrics endpoints,
+like sidecars or legacy processes, and exposes their metrics together with metrics of this service,
+so one scrape job covers the whole pod.
+
+Scraped samples get target label with the target name. A target label already set by the target
+is kept as exported_target. For every target the collector also exposes
+prometheus_proxy_target_up{target} with 1 for successful or 0 for failed scrapes and
+prometheus_proxy_scrape_duration_seconds{target}. Metrics of failed targets are not exposed.
+
+Targets are listed in configuration by names or resolved from IDiscovery services under the configured keys,
+for instance where PrometheusMetricsService registers its endpoint. Discovered targets are named by their host and port.
+Metrics are read with PrometheusCounterParser in text exposition or OpenMetrics format.
+
+The collector shall be referenced by *:metrics-relay:*:*:1.0 descriptor, so metrics services expose
+scraped metrics with exported_source and exported_instance labels and PrometheusCounters don't push them.
+
+Configuration parameters:
+
+  - discovery_keys:          (optional) comma-separated keys to resolve targets from IDiscovery services
+  - targets:                 (optional) targets by names
+    - <name>:
+      - uri:                 URI of the metrics endpoint
+      - protocol:            connection protocol: http or https (default: http)
+      - host:                host name or IP address (default: localhost)
+      - port:                port number
+      - path:                path of the metrics route (default: /metrics)
+  - options:
+    - interval:              interval in milliseconds to scrape targets (default: 15 sec)
+    - timeout:               timeout in milliseconds to scrape a target (default: 5 sec)
+    - other transport settings (connect timeout, proxy, HTTP/2): see PrometheusTransportOptions
+
+References:
+
+- *:logger:*:*:1.0               (optional)  ILogger components to pass log messages
+- *:discovery:*:*:1.0            (optional)  IDiscovery services to resolve targets
+
+Example:
+
+    collector := NewPrometheusScrapeCollector()
+    collector.Configure(cconf.NewConfigParamsFromTuples(
+        "targets.envoy.uri", "http://localhost:9901/stats/prometheus",
+        "targets.legacy.port", 9102,
+    ))
+
+    references := cref.NewReferencesFromTuples(
+        cref.NewDescriptor("pip-services", "metrics-relay", "scrape", "default", "1.0"), collector,
+    )
+    collector.SetReferences(references)
+    collector.Open("123")
+*/
+type PrometheusScrapeCollector struct {
+	lock             sync.Mutex
+	logger           *clog.CompositeLogger
+	transportOptions *PrometheusTransportOptions
+	discoveryKeys    []string
+	targets          []*cconnect.ConnectionParams
+	discoveries      []cconnect.IDiscovery
+	interval         int64
+	timeout          int64
+	client           *http.Client
+	done             chan struct{}
+	wg               sync.WaitGroup
+	results          []*scrapeTargetResult
+}
+
+// A scraped target with its metrics
+type scrapeTargetResult struct {
+	name     string
+	families []*PrometheusMetricFamily
+	duration time.Duration
+	up       bool
+}
+
+// NewPrometheusScrapeCollector creates a new instance of the collector.
+// Returns *PrometheusScrapeCollector
+// pointer on new instance
+func NewPrometheusScrapeCollector() *PrometheusScrapeCollector {
+	return &PrometheusScrapeCollector{
+		logger:           clog.NewCompositeLogger(),
+		transportOptions: NewPrometheusTransportOptions(),
+		discoveryKeys:    make([]string, 0),
+		targets:          make([]*cconnect.ConnectionParams, 0),
+		discoveries:      make([]cconnect.IDiscovery, 0),
+		interval:         15000,
+		timeout:          5000,
+		results:          make([]*scrapeTargetResult, 0),
+	}
+}
+
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+// - config   *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *PrometheusScrapeCollector) Configure(config *cconf.ConfigParams) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.transportOptions.Configure(config)
+	c.interval = config.GetAsLongWithDefault("options.interval", c.interval)
+	c.timeout = config.GetAsLongWithDefault("options.timeout", c.timeout)
+
+	keys := config.GetAsStringWithDefault("discovery_keys", "")
+	if keys != "" {
+		c.discoveryKeys = make([]string, 0)
+		for _, key := range strings.Split(keys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				c.discoveryKeys = append(c.discoveryKeys, key)
+			}
+		}
+	}
+
+	targets := config.GetSection("targets")
+	if len(targets.Keys()) > 0 {
+		names := make(map[string]bool)
+		for _, key := range targets.Keys() {
+			names[strings.Split(key, ".")[0]] = true
+		}
+
+		c.targets = make([]*cconnect.ConnectionParams, 0, len(names))
+		for name := range names {
+			target := cconnect.NewConnectionParams(targets.GetSection(name).Value())
+			target.Put("name", name)
+			// Sidecars usually run in the same pod or host
+			if target.Uri() == "" && target.Host() == "" {
+				target.SetHost("localhost")
+			}
+			c.targets = append(c.targets, target)
+		}
+		sort.Slice(c.targets, func(i, j int) bool {
+			return c.targets[i].GetAsString("name") < c.targets[j].GetAsString("name")
+		})
+	}
+}
+
+// SetReferences is sets references to dependent components.
+// Parameters:
+//   - references cref.IReferences
+// references to locate the component dependencies.
+func (c *PrometheusScrapeCollector) SetReferences(references cref.IReferences) {
+	c.logger.SetReferences(references)
+
+	discoveries := make([]cconnect.IDiscovery, 0)
+	refs := references.GetOptional(cref.NewDescriptor("*", "discovery", "*", "*", "1.0"))
+	for _, ref := range refs {
+		if discovery, ok := ref.(cconnect.IDiscovery); ok {
+			discoveries = append(discoveries, discovery)
+		}
+	}
+
+	c.lock.Lock()
+	c.discoveries = discoveries
+	c.lock.Unlock()
+}
+
+// IsOpen method are checks if the component is opened.
+// Returns true if the component has been opened and false otherwise.
+func (c *PrometheusScrapeCollector) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.client != nil
+}
+
+// Open method are opens the component and starts scraping targets.
+// The first scrape is started immediately in background.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusScrapeCollector) Open(correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client != nil {
+		return nil
+	}
+
+	transport, err := c.transportOptions.CreateTransport(correlationId)
+	if err != nil {
+		return err
+	}
+	c.client = &http.Client{Transport: transport}
+
+	done := make(chan struct{})
+	c.done = done
+	interval := time.Duration(c.interval) * time.Millisecond
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		c.Scrape(correlationId)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.Scrape(correlationId)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close method are closes component, stops scraping and forgets scraped metrics.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusScrapeCollector) Close(correlationId string) error {
+	c.lock.Lock()
+	if c.client == nil {
+		c.lock.Unlock()
+		return nil
+	}
+	close(c.done)
+	client := c.client
+	c.lock.Unlock()
+
+	// Scraping goroutine uses the lock, so it's awaited outside
+	c.wg.Wait()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	client.CloseIdleConnections()
+	c.client = nil
+	c.done = nil
+	c.results = make([]*scrapeTargetResult, 0)
+	return nil
+}
+
+// Scrape method scrapes all targets concurrently and replaces previously scraped metrics.
+// It is called periodically when the collector is opened and does nothing when it is closed.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+func (c *PrometheusScrapeCollector) Scrape(correlationId string) {
+	c.lock.Lock()
+	client := c.client
+	c.lock.Unlock()
+	if client == nil {
+		return
+	}
+
+	names, addresses := c.resolveTargets(correlationId)
+
+	results := make([]*scrapeTargetResult, len(names))
+	var wg sync.WaitGroup
+	wg.Add(len(names))
+	for index := range names {
+		go func(index int) {
+			defer wg.Done()
+			results[index] = c.scrapeTarget(correlationId, client, names[index], addresses[index])
+		}(index)
+	}
+	wg.Wait()
+
+	c.lock.Lock()
+	if c.client == client {
+		c.results = results
+	}
+	c.lock.Unlock()
+}
+
+// Collect method sends metrics scraped from targets to the channel.
+//   - ch    a channel to send collected metric families.
+func (c *PrometheusScrapeCollector) Collect(ch chan<- *PrometheusMetricFamily) {
+	c.lock.Lock()
+	results := c.results
+	c.lock.Unlock()
+
+	if len(results) == 0 {
+		return
+	}
+
+	up := NewPrometheusMetricFamily(scrapeTargetUpMetric, PrometheusGauge,
+		"Whether the last scrape of the target was successful.")
+	duration := NewPrometheusMetricFamily(scrapeTargetDurationMetric, PrometheusGauge,
+		"Duration of the last scrape of the target in seconds.")
+
+	for _, result := range results {
+		labels := map[string]string{"target": result.name}
+		value := 0.0
+		if result.up {
+			value = 1
+		}
+		up.AddSample(scrapeTargetUpMetric, labels, value)
+		duration.AddSample(scrapeTargetDurationMetric, labels, result.duration.Seconds())
+
+		for _, family := range result.families {
+			ch <- c.withTarget(family, result.name)
+		}
+	}
+
+	ch <- up
+	ch <- duration
+}
+
+// Copies the family adding target label to its samples, so scraped metrics are not changed
+func (c *PrometheusScrapeCollector) withTarget(family *PrometheusMetricFamily, target string) *PrometheusMetricFamily {
+	result := NewPrometheusMetricFamily(family.Name, family.Type, family.Help)
+	for _, sample := range family.Samples {
+		labels := make(map[string]string, len(sample.Labels)+1)
+		for key, value := range sample.Labels {
+			labels[key] = value
+		}
+		if value, ok := labels["target"]; ok {
+			labels["exported_target"] = value
+		}
+		labels["target"] = target
+
+		result.Samples = append(result.Samples, &PrometheusSample{Name: sample.Name, Labels: labels,
+			Value: sample.Value, Timestamp: sample.Timestamp, Exemplar: sample.Exemplar})
+	}
+	return result
+}
+
+// Resolves names and URLs of configured and discovered targets
+func (c *PrometheusScrapeCollector) resolveTargets(correlationId string) ([]string, []string) {
+	c.lock.Lock()
+	targets := c.targets
+	keys := c.discoveryKeys
+	discoveries := c.discoveries
+	c.lock.Unlock()
+
+	names := make([]string, 0, len(targets))
+	addresses := make([]string, 0, len(targets))
+	seen := make(map[string]bool)
+
+	add := func(name string, connection *cconnect.ConnectionParams) {
+		address := c.targetUrl(connection)
+		if address == nil {
+			c.logger.Warn(correlationId, "Skipped scrape target %s without host and port", name)
+			return
+		}
+		if name == "" {
+			name = address.Host
+		}
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+		addresses = append(addresses, address.String())
+	}
+
+	for _, target := range targets {
+		add(target.GetAsString("name"), target)
+	}
+
+	for _, key := range keys {
+		for _, discovery := range discoveries {
+			connections, err := discovery.ResolveAll(correlationId, key)
+			if err != nil {
+				c.logger.Error(correlationId, err, "Failed to resolve scrape targets by key %s", key)
+				continue
+			}
+			for _, connection := range connections {
+				if connection != nil {
+					add("", connection)
+				}
+			}
+		}
+	}
+
+	return names, addresses
+}
+
+// Composes URL of metrics endpoint from a configured or discovered connection
+func (c *PrometheusScrapeCollector) targetUrl(connection *cconnect.ConnectionParams) *url.URL {
+	if connection.Uri() != "" {
+		address, err := url.Parse(connection.Uri())
+		if err != nil || address.Host == "" {
+			return nil
+		}
+		return address
+	}
+
+	if connection.Host() == "" || connection.Port() <= 0 {
+		return nil
+	}
+
+	// Discovered connections registered by metrics services keep scheme and path in labels
+	scheme := connection.GetAsStringWithDefault("labels.scheme", connection.Protocol())
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := connection.GetAsStringWithDefault("path", connection.GetAsString("labels.path"))
+	if path == "" {
+		path = "/metrics"
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return &url.URL{
+		Scheme: strings.ToLower(scheme),
+		Host:   net.JoinHostPort(connection.Host(), strconv.Itoa(connection.Port())),
+		Path:   path,
+	}
+}
+
+func (c *PrometheusScrapeCollector) scrapeTarget(correlationId string, client *http.Client,
+	name string, address string) *scrapeTargetResult {
+	result := &scrapeTargetResult{name: name}
+	start := time.Now()
+
+	families, err := c.readTarget(correlationId, client, address)
+	result.duration = time.Since(start)
+	if err != nil {
+		c.logger.Warn(correlationId, "Failed to scrape target %s at %s: %s", name, address, err.Error())
+		return result
+	}
+
+	result.up = true
+	result.families = families
+	return result
+}
+
+func (c *PrometheusScrapeCollector) readTarget(correlationId string, client *http.Client,
+	address string) ([]*PrometheusMetricFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.timeout)*time.Millisecond)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return nil, cerr.NewConfigError(correlationId, "INVALID_TARGET", "Scrape target URL is invalid").
+			WithDetails("url", address).WithCause(err)
+	}
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0;q=0.9,text/plain;version=0.0.4;q=0.5")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, cerr.NewConnectionError(correlationId, "CANNOT_CONNECT", "Failed to scrape target").
+			WithDetails("url", address).WithCause(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, cerr.NewConnectionError(correlationId, "CANNOT_CONNECT", "Failed to read scraped metrics").
+			WithDetails("url", address).WithCause(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, cerr.NewInvocationError(correlationId, "SCRAPE_FAILED", "Target responded with status "+res.Status).
+			WithDetails("url", address).WithDetails("status", res.StatusCode)
+	}
+
+	return PrometheusCounterParser.Parse(correlationId, res.Header.Get("Content-Type"), string(body))
+}
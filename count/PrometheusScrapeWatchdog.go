@@ -0,0 +1,104 @@
+package count
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+)
+
+// Modes of pushing metrics to Prometheus PushGateway
+const (
+	PrometheusPushAlways   = "always"
+	PrometheusPushFallback = "fallback"
+)
+
+// prometheusScrapeWatchdog decides if metrics shall be pushed in hybrid mode.
+// In fallback mode metrics are pushed only when no scrapes arrived within the scrape window.
+// When pushing stops, the pushed group stays pending for deletion until it's deleted successfully.
+//
+// Configuration parameters:
+//   - push:
+//     - mode:             push mode: always or fallback (default: always)
+//     - scrape_window:    time in milliseconds without scrapes to start pushing in fallback mode (default: 60 sec)
+type prometheusScrapeWatchdog struct {
+	lock       sync.Mutex
+	mode       string
+	window     int64
+	lastScrape time.Time
+	pushing    bool
+	deleting   bool
+}
+
+func newPrometheusScrapeWatchdog() *prometheusScrapeWatchdog {
+	return &prometheusScrapeWatchdog{
+		mode:   PrometheusPushAlways,
+		window: 60000,
+	}
+}
+
+func (c *prometheusScrapeWatchdog) Configure(config *cconf.ConfigParams) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.mode = strings.ToLower(config.GetAsStringWithDefault("push.mode", c.mode))
+	c.window = config.GetAsLongWithDefault("push.scrape_window", c.window)
+}
+
+func (c *prometheusScrapeWatchdog) Mode() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.mode
+}
+
+// Reset starts a new scrape window, so pushing doesn't start right after opening
+func (c *prometheusScrapeWatchdog) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.lastScrape = time.Now()
+	c.pushing = false
+}
+
+// NotifyScrape remembers the time of the last scrape
+func (c *prometheusScrapeWatchdog) NotifyScrape() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.lastScrape = time.Now()
+}
+
+// ShouldPush checks if metrics shall be pushed now.
+// Returns true to push, and true when pushing was started or stopped since the previous check.
+func (c *prometheusScrapeWatchdog) ShouldPush() (bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.mode != PrometheusPushFallback {
+		return true, false
+	}
+
+	pushing := time.Since(c.lastScrape) > time.Duration(c.window)*time.Millisecond
+	changed := pushing != c.pushing
+	c.pushing = pushing
+	if changed {
+		// A new push replaces the group, so it doesn't need to be deleted anymore
+		c.deleting = !pushing
+	}
+	return pushing, changed
+}
+
+// IsDeletePending checks if the pushed group shall be deleted since pushing stopped
+func (c *prometheusScrapeWatchdog) IsDeletePending() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.deleting
+}
+
+// Deleted confirms that the pushed group was deleted
+func (c *prometheusScrapeWatchdog) Deleted() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.deleting = false
+}
@@ -0,0 +1,297 @@
+package count
+
+import (
+	"regexp"
+	"strings"
+
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+)
+
+// Types of label matchers in series selectors
+const (
+	PrometheusMatchEqual     = "="
+	PrometheusMatchNotEqual  = "!="
+	PrometheusMatchRegexp    = "=~"
+	PrometheusMatchNotRegexp = "!~"
+)
+
+// PrometheusLabelMatcher matches a single label of a series.
+// Metric name is matched as __name__ label. Missing labels are matched as empty values.
+type PrometheusLabelMatcher struct {
+	Name  string
+	Type  string
+	Value string
+	regex *regexp.Regexp
+}
+
+// NewPrometheusLabelMatcher creates a new label matcher.
+//   - name     a label name.
+//   - typ      a matcher type: =, !=, =~ or !~.
+//   - value    a label value or a regular expression that is matched against the whole value.
+// Returns *PrometheusLabelMatcher, error
+// new matcher or error if the type or the regular expression is invalid.
+func NewPrometheusLabelMatcher(name string, typ string, value string) (*PrometheusLabelMatcher, error) {
+	c := &PrometheusLabelMatcher{Name: name, Type: typ, Value: value}
+
+	switch typ {
+	case PrometheusMatchEqual, PrometheusMatchNotEqual:
+	case PrometheusMatchRegexp, PrometheusMatchNotRegexp:
+		regex, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, cerr.NewBadRequestError("", "INVALID_SELECTOR", "Invalid regular expression "+value).
+				WithDetails("regex", value).WithCause(err)
+		}
+		c.regex = regex
+	default:
+		return nil, cerr.NewBadRequestError("", "INVALID_SELECTOR", "Invalid matcher type "+typ).
+			WithDetails("type", typ)
+	}
+
+	return c, nil
+}
+
+// Matches method checks if the label value satisfies the matcher.
+//   - value    a label value, empty when the label is missing.
+// Returns true if the value matches.
+func (c *PrometheusLabelMatcher) Matches(value string) bool {
+	switch c.Type {
+	case PrometheusMatchEqual:
+		return value == c.Value
+	case PrometheusMatchNotEqual:
+		return value != c.Value
+	case PrometheusMatchRegexp:
+		return c.regex.MatchString(value)
+	case PrometheusMatchNotRegexp:
+		return !c.regex.MatchString(value)
+	}
+	return false
+}
+
+/*
+PrometheusSeriesSelector selects series by metric name and label matchers,
+like in match[] parameter of Prometheus federation:
+
+    http_requests_total
+    http_requests_total{method="GET",code=~"5.."}
+    {__name__=~"job_.*",source!="test"}
+
+All matchers of the selector must match. Like in Prometheus, a selector must have
+at least one matcher that doesn't match empty values, so it can't select all series by accident.
+*/
+type PrometheusSeriesSelector struct {
+	Matchers []*PrometheusLabelMatcher
+}
+
+// ParsePrometheusSeriesSelector parses a series selector.
+//   - selector    a series selector like metric_name{label="value"}.
+// Returns *PrometheusSeriesSelector, error
+// parsed selector or BadRequestError if the selector is invalid.
+func ParsePrometheusSeriesSelector(selector string) (*PrometheusSeriesSelector, error) {
+	parser := &prometheusSelectorParser{text: strings.TrimSpace(selector)}
+	result, err := parser.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, matcher := range result.Matchers {
+		if !matcher.Matches("") {
+			return result, nil
+		}
+	}
+	return nil, parser.error("Selector must contain at least one matcher that doesn't match empty values")
+}
+
+// Matches method checks if the sample satisfies all matchers of the selector.
+//   - sample    a sample to check.
+// Returns true if the sample matches.
+func (c *PrometheusSeriesSelector) Matches(sample *PrometheusSample) bool {
+	for _, matcher := range c.Matchers {
+		value := sample.Labels[matcher.Name]
+		if matcher.Name == "__name__" {
+			value = sample.Name
+		}
+		if !matcher.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterMetricFamilies returns families with samples that match any of selectors.
+// Families without matched samples are omitted.
+//   - families     metric families to filter.
+//   - selectors    series selectors.
+// Returns []*PrometheusMetricFamily
+// filtered metric families
+func FilterMetricFamilies(families []*PrometheusMetricFamily, selectors []*PrometheusSeriesSelector) []*PrometheusMetricFamily {
+	result := make([]*PrometheusMetricFamily, 0)
+	for _, family := range families {
+		var filtered *PrometheusMetricFamily
+		for _, sample := range family.Samples {
+			for _, selector := range selectors {
+				if selector.Matches(sample) {
+					if filtered == nil {
+						filtered = NewPrometheusMetricFamily(family.Name, family.Type, family.Help)
+					}
+					filtered.Samples = append(filtered.Samples, sample)
+					break
+				}
+			}
+		}
+		if filtered != nil {
+			result = append(result, filtered)
+		}
+	}
+	return result
+}
+
+// Parses selectors with a simple recursive descent over the text
+type prometheusSelectorParser struct {
+	text     string
+	position int
+}
+
+func (c *prometheusSelectorParser) error(message string) error {
+	return cerr.NewBadRequestError("", "INVALID_SELECTOR", message).
+		WithDetails("selector", c.text).WithDetails("position", c.position)
+}
+
+func (c *prometheusSelectorParser) parse() (*PrometheusSeriesSelector, error) {
+	result := &PrometheusSeriesSelector{Matchers: make([]*PrometheusLabelMatcher, 0)}
+
+	if name := c.readName(true); name != "" {
+		matcher, _ := NewPrometheusLabelMatcher("__name__", PrometheusMatchEqual, name)
+		result.Matchers = append(result.Matchers, matcher)
+	}
+
+	c.skipSpaces()
+	if c.position == len(c.text) {
+		if len(result.Matchers) == 0 {
+			return nil, c.error("Selector is empty")
+		}
+		return result, nil
+	}
+
+	if c.text[c.position] != '{' {
+		return nil, c.error("Expected { in selector")
+	}
+	c.position++
+
+	for {
+		c.skipSpaces()
+		if c.position < len(c.text) && c.text[c.position] == '}' {
+			c.position++
+			break
+		}
+
+		name := c.readName(false)
+		if name == "" {
+			return nil, c.error("Expected label name in selector")
+		}
+
+		c.skipSpaces()
+		typ := c.readMatchType()
+		if typ == "" {
+			return nil, c.error("Expected label matcher in selector")
+		}
+
+		c.skipSpaces()
+		value, err := c.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		matcher, err := NewPrometheusLabelMatcher(name, typ, value)
+		if err != nil {
+			return nil, err
+		}
+		result.Matchers = append(result.Matchers, matcher)
+
+		c.skipSpaces()
+		if c.position < len(c.text) && c.text[c.position] == ',' {
+			c.position++
+			continue
+		}
+		if c.position < len(c.text) && c.text[c.position] == '}' {
+			c.position++
+			break
+		}
+		return nil, c.error("Expected , or } in selector")
+	}
+
+	c.skipSpaces()
+	if c.position != len(c.text) {
+		return nil, c.error("Unexpected characters after selector")
+	}
+	return result, nil
+}
+
+func (c *prometheusSelectorParser) skipSpaces() {
+	for c.position < len(c.text) && strings.ContainsRune(" \t\r\n", rune(c.text[c.position])) {
+		c.position++
+	}
+}
+
+// Reads metric name (with colons) or label name
+func (c *prometheusSelectorParser) readName(metric bool) string {
+	start := c.position
+	for c.position < len(c.text) {
+		ch := c.text[c.position]
+		isLetter := ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (metric && ch == ':')
+		isDigit := ch >= '0' && ch <= '9'
+		if !isLetter && !(isDigit && c.position > start) {
+			break
+		}
+		c.position++
+	}
+	return c.text[start:c.position]
+}
+
+func (c *prometheusSelectorParser) readMatchType() string {
+	for _, typ := range []string{PrometheusMatchRegexp, PrometheusMatchNotRegexp, PrometheusMatchNotEqual, PrometheusMatchEqual} {
+		if strings.HasPrefix(c.text[c.position:], typ) {
+			c.position += len(typ)
+			return typ
+		}
+	}
+	return ""
+}
+
+// Reads a string in double or single quotes with escapes or a raw string in backticks
+func (c *prometheusSelectorParser) readString() (string, error) {
+	if c.position >= len(c.text) || !strings.ContainsRune("\"'`", rune(c.text[c.position])) {
+		return "", c.error("Expected quoted label value in selector")
+	}
+	quote := c.text[c.position]
+	c.position++
+
+	var builder strings.Builder
+	for c.position < len(c.text) {
+		ch := c.text[c.position]
+		c.position++
+
+		if ch == quote {
+			return builder.String(), nil
+		}
+		if ch == '\\' && quote != '`' && c.position < len(c.text) {
+			escaped := c.text[c.position]
+			c.position++
+			switch escaped {
+			case 'n':
+				builder.WriteByte('\n')
+			case 't':
+				builder.WriteByte('\t')
+			case '\\', '"', '\'':
+				builder.WriteByte(escaped)
+			default:
+				// Unknown escapes are kept for regular expressions like "a\.b"
+				builder.WriteByte('\\')
+				builder.WriteByte(escaped)
+			}
+			continue
+		}
+		builder.WriteByte(ch)
+	}
+
+	return "", c.error("Unterminated label value in selector")
+}
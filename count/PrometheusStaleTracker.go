@@ -0,0 +1,138 @@
+package count
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
+)
+
+// Modes of handling stale series
+const (
+	PrometheusStaleMark   = "mark"
+	PrometheusStaleRemove = "remove"
+)
+
+// prometheusStaleTracker finds counters that stopped updating.
+// CachedCounters don't keep time of the last update, so a counter is considered
+// updated when any of its values changed since it was seen last time.
+//
+// Configuration parameters:
+//   - stale:
+//     - ttl:              time in milliseconds after which a series that isn't updated is stale, 0 to disable (default: 0)
+//     - metrics:          TTLs for individual counters or metrics by names, names ending with * match prefixes
+type prometheusStaleTracker struct {
+	lock        sync.Mutex
+	ttl         int64
+	ttls        map[string]int64
+	prefixes    []string
+	lastValues  map[string]ccount.Counter
+	lastChanges map[string]time.Time
+}
+
+func newPrometheusStaleTracker() *prometheusStaleTracker {
+	return &prometheusStaleTracker{
+		ttls:        make(map[string]int64),
+		prefixes:    make([]string, 0),
+		lastValues:  make(map[string]ccount.Counter),
+		lastChanges: make(map[string]time.Time),
+	}
+}
+
+func (c *prometheusStaleTracker) Configure(config *cconf.ConfigParams) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ttl = config.GetAsLongWithDefault("stale.ttl", c.ttl)
+
+	metrics := config.GetSection("stale.metrics")
+	for _, name := range metrics.Keys() {
+		c.ttls[name] = metrics.GetAsLongWithDefault(name, 0)
+	}
+
+	// Longer prefixes are more specific and checked first
+	c.prefixes = make([]string, 0)
+	for name := range c.ttls {
+		if strings.HasSuffix(name, "*") {
+			c.prefixes = append(c.prefixes, name)
+		}
+	}
+	sort.Slice(c.prefixes, func(i, j int) bool { return len(c.prefixes[i]) > len(c.prefixes[j]) })
+}
+
+// Enabled returns true when any TTL is configured
+func (c *prometheusStaleTracker) Enabled() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ttl > 0 || len(c.ttls) > 0
+}
+
+// Ttl returns TTL for a counter or metric name, 0 when it never gets stale
+func (c *prometheusStaleTracker) Ttl(name string) time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ttlFor(name)
+}
+
+func (c *prometheusStaleTracker) ttlFor(name string) time.Duration {
+	ttl, ok := c.ttls[name]
+	if !ok {
+		ttl = c.ttl
+		for _, prefix := range c.prefixes {
+			if strings.HasPrefix(name, strings.TrimSuffix(prefix, "*")) {
+				ttl = c.ttls[prefix]
+				break
+			}
+		}
+	}
+	return time.Duration(ttl) * time.Millisecond
+}
+
+// Split divides counters into fresh and stale ones and remembers their current values
+func (c *prometheusStaleTracker) Split(counters []*ccount.Counter) ([]*ccount.Counter, []*ccount.Counter) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	fresh := make([]*ccount.Counter, 0, len(counters))
+	stale := make([]*ccount.Counter, 0)
+	seen := make(map[string]bool, len(counters))
+
+	for _, counter := range counters {
+		seen[counter.Name] = true
+
+		last, ok := c.lastValues[counter.Name]
+		if !ok || last != *counter {
+			c.lastValues[counter.Name] = *counter
+			c.lastChanges[counter.Name] = now
+		}
+
+		ttl := c.ttlFor(counter.Name)
+		if ttl > 0 && now.Sub(c.lastChanges[counter.Name]) > ttl {
+			stale = append(stale, counter)
+		} else {
+			fresh = append(fresh, counter)
+		}
+	}
+
+	// Forget removed counters, so they start fresh when recorded again
+	for name := range c.lastValues {
+		if !seen[name] {
+			delete(c.lastValues, name)
+			delete(c.lastChanges, name)
+		}
+	}
+
+	return fresh, stale
+}
+
+// Forget removes the counter from tracking
+func (c *prometheusStaleTracker) Forget(name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.lastValues, name)
+	delete(c.lastChanges, name)
+}
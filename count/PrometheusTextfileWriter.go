@@ -0,0 +1,129 @@
+package count
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+)
+
+// prometheusTextfileWriter writes metrics into a .prom file for node_exporter textfile collector.
+// Files are replaced atomically, so node_exporter never reads a partially written file.
+//
+// Configuration parameters:
+//   - textfile:
+//     - path:             path of the file with {source} and {instance} placeholders, empty to disable
+//     - remove_on_close:  remove the file when the component is closed (default: false)
+type prometheusTextfileWriter struct {
+	template      string
+	removeOnClose bool
+	path          string
+}
+
+func newPrometheusTextfileWriter() *prometheusTextfileWriter {
+	return &prometheusTextfileWriter{}
+}
+
+func (c *prometheusTextfileWriter) Configure(config *cconf.ConfigParams) {
+	c.template = config.GetAsStringWithDefault("textfile.path", c.template)
+	c.removeOnClose = config.GetAsBooleanWithDefault("textfile.remove_on_close", c.removeOnClose)
+}
+
+// Enabled returns true when the file path is resolved by Open
+func (c *prometheusTextfileWriter) Enabled() bool {
+	return c.path != ""
+}
+
+// Open resolves the file path from the template
+func (c *prometheusTextfileWriter) Open(correlationId string, source string, instance string) error {
+	if c.template == "" {
+		return nil
+	}
+
+	path := strings.Replace(c.template, "{source}", c.sanitize(source), -1)
+	path = strings.Replace(path, "{instance}", c.sanitize(instance), -1)
+	if filepath.Ext(path) != ".prom" {
+		return cerr.NewConfigError(correlationId, "INVALID_TEXTFILE_PATH",
+			"Textfile path must have .prom extension to be read by node_exporter").WithDetails("path", path)
+	}
+
+	c.path = path
+	return nil
+}
+
+// Write replaces the file with the given metrics: writes a temporary file in the same directory,
+// syncs it to disk and renames it over the target file
+func (c *prometheusTextfileWriter) Write(correlationId string, families []*PrometheusMetricFamily) error {
+	if c.path == "" {
+		return nil
+	}
+
+	body := PrometheusCounterConverter.FamiliesToString(families)
+
+	// The temporary file has another extension, so node_exporter ignores it
+	file, err := ioutil.TempFile(filepath.Dir(c.path), "."+filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return c.error(correlationId, "Failed to create temporary textfile", err)
+	}
+	tempPath := file.Name()
+
+	_, err = file.WriteString(body)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// Temporary files are created readable only by the owner
+		err = os.Chmod(tempPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, c.path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return c.error(correlationId, "Failed to write textfile", err)
+	}
+
+	return nil
+}
+
+// Close removes the file when it is configured and forgets its path
+func (c *prometheusTextfileWriter) Close(correlationId string) error {
+	if c.path == "" || !c.removeOnClose {
+		c.path = ""
+		return nil
+	}
+
+	err := os.Remove(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		err = c.error(correlationId, "Failed to remove textfile", err)
+	} else {
+		err = nil
+	}
+	c.path = ""
+	return err
+}
+
+func (c *prometheusTextfileWriter) error(correlationId string, message string, cause error) error {
+	return cerr.NewFileError(correlationId, "TEXTFILE_FAILED", message).
+		WithDetails("path", c.path).WithCause(cause)
+}
+
+// Replaces characters that can't be used in file names
+func (c *prometheusTextfileWriter) sanitize(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return strings.Map(func(ch rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, ch) || ch < ' ' {
+			return '_'
+		}
+		return ch
+	}, value)
+}
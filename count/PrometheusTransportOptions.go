@@ -0,0 +1,136 @@
+package count
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+)
+
+/*
+PrometheusTransportOptions holds settings of the HTTP transport used to push metrics to Prometheus PushGateway.
+
+Configuration parameters:
+
+  - options:
+    - connect_timeout:                dial and connection timeout in milliseconds (default: 10 sec)
+    - tls_handshake_timeout:          TLS handshake timeout in milliseconds (default: 10 sec)
+    - keep_alive:                     TCP keep-alive period in milliseconds, 0 to disable (default: 30 sec)
+    - idle_timeout:                   timeout to close idle connections in milliseconds (default: 90 sec)
+    - max_idle_connections:           maximum number of idle connections, 0 for no limit (default: 100)
+    - max_idle_connections_per_host:  maximum number of idle connections per host (default: 2)
+    - max_connections_per_host:       maximum number of connections per host, 0 for no limit (default: 0)
+    - proxy:                          (optional) URL of HTTP proxy server
+    - proxy_from_env:                 use HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables when proxy is not set (default: true)
+    - http2:                          attempt HTTP/2 over TLS connections (default: true)
+
+Example:
+
+    options := NewPrometheusTransportOptions()
+    options.Configure(cconf.NewConfigParamsFromTuples(
+        "options.connect_timeout", 5000,
+        "options.proxy", "http://proxy:3128",
+    ))
+
+    transport, err := options.CreateTransport("123")
+*/
+type PrometheusTransportOptions struct {
+	ConnectTimeout      int
+	TlsHandshakeTimeout int
+	KeepAlive           int
+	IdleTimeout         int
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int
+	Proxy               string
+	ProxyFromEnv        bool
+	Http2               bool
+}
+
+// NewPrometheusTransportOptions creates transport options with default values.
+// Returns *PrometheusTransportOptions
+// pointer on new instance
+func NewPrometheusTransportOptions() *PrometheusTransportOptions {
+	return &PrometheusTransportOptions{
+		ConnectTimeout:      10000,
+		TlsHandshakeTimeout: 10000,
+		KeepAlive:           30000,
+		IdleTimeout:         90000,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: http.DefaultMaxIdleConnsPerHost,
+		MaxConnsPerHost:     0,
+		ProxyFromEnv:        true,
+		Http2:               true,
+	}
+}
+
+// Configure method are configures transport options by passing configuration parameters.
+// Parameters:
+// - config   *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *PrometheusTransportOptions) Configure(config *cconf.ConfigParams) {
+	// Kept for backward compatibility with configurations written for earlier versions
+	c.ConnectTimeout = config.GetAsIntegerWithDefault("options.connectTimeout", c.ConnectTimeout)
+	c.ConnectTimeout = config.GetAsIntegerWithDefault("options.connect_timeout", c.ConnectTimeout)
+	c.TlsHandshakeTimeout = config.GetAsIntegerWithDefault("options.tls_handshake_timeout", c.TlsHandshakeTimeout)
+	c.KeepAlive = config.GetAsIntegerWithDefault("options.keep_alive", c.KeepAlive)
+	c.IdleTimeout = config.GetAsIntegerWithDefault("options.idle_timeout", c.IdleTimeout)
+	c.MaxIdleConns = config.GetAsIntegerWithDefault("options.max_idle_connections", c.MaxIdleConns)
+	c.MaxIdleConnsPerHost = config.GetAsIntegerWithDefault("options.max_idle_connections_per_host", c.MaxIdleConnsPerHost)
+	c.MaxConnsPerHost = config.GetAsIntegerWithDefault("options.max_connections_per_host", c.MaxConnsPerHost)
+	c.Proxy = config.GetAsStringWithDefault("options.proxy", c.Proxy)
+	c.ProxyFromEnv = config.GetAsBooleanWithDefault("options.proxy_from_env", c.ProxyFromEnv)
+	c.Http2 = config.GetAsBooleanWithDefault("options.http2", c.Http2)
+}
+
+// CreateTransport method creates a new HTTP transport with the configured settings.
+// Parameters:
+// - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns *http.Transport, error
+// created transport or error if proxy URL is invalid.
+func (c *PrometheusTransportOptions) CreateTransport(correlationId string) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(c.ConnectTimeout) * time.Millisecond,
+		KeepAlive: time.Duration(c.KeepAlive) * time.Millisecond,
+	}
+	// Negative keep-alive period disables keep-alive probes in net.Dialer
+	if c.KeepAlive <= 0 {
+		dialer.KeepAlive = -1
+	}
+
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: time.Duration(c.TlsHandshakeTimeout) * time.Millisecond,
+		IdleConnTimeout:     time.Duration(c.IdleTimeout) * time.Millisecond,
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		ForceAttemptHTTP2:   c.Http2,
+	}
+
+	if !c.Http2 {
+		// A non-nil empty map disables HTTP/2 negotiation over TLS
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if c.Proxy != "" {
+		proxyUrl, err := url.Parse(c.Proxy)
+		if err != nil || proxyUrl.Scheme == "" || proxyUrl.Host == "" {
+			ex := cerr.NewConfigError(correlationId, "INVALID_PROXY", "Proxy URL is invalid").WithDetails("proxy", c.Proxy)
+			if err != nil {
+				ex = ex.WithCause(err)
+			}
+			return nil, ex
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	} else if c.ProxyFromEnv {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	return transport, nil
+}
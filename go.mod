@@ -3,6 +3,7 @@ module github.com/pip-services3-go/pip-services3-prometheus-go
 go 1.16
 
 require (
+	github.com/gorilla/mux v1.8.0
 	github.com/pip-services3-go/pip-services3-commons-go v1.1.6
 	github.com/pip-services3-go/pip-services3-components-go v1.3.2
 	github.com/pip-services3-go/pip-services3-rpc-go v1.5.2
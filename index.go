@@ -2,6 +2,7 @@ package prometheus
 
 import (
 	_ "github.com/pip-services3-go/pip-services3-prometheus-go/build"
+	_ "github.com/pip-services3-go/pip-services3-prometheus-go/clients"
 	_ "github.com/pip-services3-go/pip-services3-prometheus-go/count"
 	_ "github.com/pip-services3-go/pip-services3-prometheus-go/services"
 )
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	rpcservices "github.com/pip-services3-go/pip-services3-rpc-go/services"
+)
+
+// Names of metrics recorded by HttpMetricsInterceptor
+const (
+	httpRequestsMetric     = "http_requests_total"
+	httpDurationMetric     = "http_request_duration_seconds"
+	httpRequestSizeMetric  = "http_request_size_bytes"
+	httpResponseSizeMetric = "http_response_size_bytes"
+)
+
+// Default buckets for request and response sizes in bytes
+var httpSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+/*
+HttpMetricsInterceptor is a component that instruments HTTP endpoints and records metrics
+about every handled request into PrometheusCounters registry:
+
+  - http_requests_total{route,method,code}           total number of requests
+  - http_request_duration_seconds{route,method}      histogram of request durations
+  - http_request_size_bytes{route,method}            histogram of request body sizes
+  - http_response_size_bytes{route,method}           histogram of response body sizes
+
+The route label contains the registered route template like /v1/items/{id}, not the raw path,
+so the number of series stays bounded. Requests that don't match any route are not recorded.
+When a request has correlation_id query parameter or header, it is attached to the request counter
+and the duration histogram as an exemplar.
+
+The interceptor is added to all referenced HTTP endpoints. Services with their own endpoints
+can be instrumented by calling Instrument method after references are set.
+
+Configuration parameters:
+
+  - dependencies:
+    - endpoint:              override for HTTP Endpoint dependency
+    - prometheus-counters:   override for PrometheusCounters dependency
+  - options:
+    - duration_buckets:      (optional) comma-separated upper bounds of duration buckets in seconds
+
+References:
+
+- *:endpoint:http:*:1.0          (optional) HttpEndpoint references to instrument
+- *:counters:prometheus:*:1.0    PrometheusCounters reference to record metrics
+
+Example:
+
+    interceptor := NewHttpMetricsInterceptor()
+    interceptor.SetReferences(cref.NewReferencesFromTuples(
+        cref.NewDescriptor("pip-services", "endpoint", "http", "default", "1.0"), endpoint,
+        cref.NewDescriptor("pip-services", "counters", "prometheus", "default", "1.0"), counters,
+    ))
+
+    // Instruments a service with its own endpoint
+    interceptor.Instrument(service.Endpoint)
+*/
+type HttpMetricsInterceptor struct {
+	dependencyResolver *cref.DependencyResolver
+	counters           *pcount.PrometheusCounters
+	durationBuckets    []float64
+}
+
+// Registers the interceptor in a single endpoint
+type httpMetricsRegistration struct {
+	interceptor *HttpMetricsInterceptor
+	endpoint    *rpcservices.HttpEndpoint
+}
+
+func (c *httpMetricsRegistration) Register() {
+	c.endpoint.RegisterInterceptor("", c.interceptor.intercept)
+}
+
+// NewHttpMetricsInterceptor creates a new instance of the interceptor.
+// Returns *HttpMetricsInterceptor
+// pointer on new instance
+func NewHttpMetricsInterceptor() *HttpMetricsInterceptor {
+	c := &HttpMetricsInterceptor{}
+	c.dependencyResolver = cref.NewDependencyResolver()
+	c.dependencyResolver.Put("endpoint", cref.NewDescriptor("*", "endpoint", "http", "*", "1.0"))
+	c.dependencyResolver.Put("prometheus-counters", cref.NewDescriptor("pip-services", "counters", "prometheus", "*", "1.0"))
+	return c
+}
+
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+//   - config *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *HttpMetricsInterceptor) Configure(config *cconf.ConfigParams) {
+	c.dependencyResolver.Configure(config)
+
+	buckets := config.GetAsStringWithDefault("options.duration_buckets", "")
+	if buckets != "" {
+		c.durationBuckets = make([]float64, 0)
+		for _, item := range strings.Split(buckets, ",") {
+			value, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+			if err == nil {
+				c.durationBuckets = append(c.durationBuckets, value)
+			}
+		}
+	}
+}
+
+// SetReferences is sets references to dependent components
+// and adds the interceptor to referenced HTTP endpoints.
+// Parameters:
+//   - references cref.IReferences
+// references to locate the component dependencies.
+func (c *HttpMetricsInterceptor) SetReferences(references cref.IReferences) {
+	c.dependencyResolver.SetReferences(references)
+
+	resolv := c.dependencyResolver.GetOneOptional("prometheus-counters")
+	c.counters, _ = resolv.(*pcount.PrometheusCounters)
+
+	for _, ref := range c.dependencyResolver.GetOptional("endpoint") {
+		if endpoint, ok := ref.(*rpcservices.HttpEndpoint); ok {
+			c.Instrument(endpoint)
+		}
+	}
+}
+
+// Instrument method adds the interceptor to the HTTP endpoint.
+// It must be called before the endpoint is opened.
+//   - endpoint    an endpoint to instrument.
+func (c *HttpMetricsInterceptor) Instrument(endpoint *rpcservices.HttpEndpoint) {
+	if endpoint == nil {
+		return
+	}
+	endpoint.Register(&httpMetricsRegistration{interceptor: c, endpoint: endpoint})
+}
+
+// Measures request and records its metrics
+func (c *HttpMetricsInterceptor) intercept(res http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	if c.counters == nil {
+		next(res, req)
+		return
+	}
+
+	route := ""
+	if current := mux.CurrentRoute(req); current != nil {
+		route, _ = current.GetPathTemplate()
+	}
+	if route == "" {
+		next(res, req)
+		return
+	}
+
+	var body *httpMetricsBody
+	if req.Body != nil && req.Body != http.NoBody {
+		body = &httpMetricsBody{ReadCloser: req.Body}
+		req.Body = body
+	}
+	writer := &httpMetricsResponseWriter{ResponseWriter: res, status: http.StatusOK}
+
+	start := time.Now()
+	next(writer, req)
+	duration := time.Since(start).Seconds()
+
+	requestSize := req.ContentLength
+	if requestSize < 0 {
+		requestSize = 0
+		if body != nil {
+			requestSize = body.size
+		}
+	}
+
+	registry := c.counters.Registry()
+	method := strings.ToUpper(req.Method)
+	labels := map[string]string{"route": route, "method": method}
+
+	var requestExemplar, durationExemplar *pcount.PrometheusExemplar
+	if correlationId := c.getCorrelationId(req); correlationId != "" {
+		requestExemplar = pcount.NewPrometheusExemplar(correlationId, 1)
+		durationExemplar = pcount.NewPrometheusExemplar(correlationId, duration)
+	}
+
+	registry.AddCounterWithExemplar(httpRequestsMetric, "Total number of HTTP requests",
+		map[string]string{"route": route, "method": method, "code": strconv.Itoa(writer.status)}, 1, requestExemplar)
+	registry.ObserveHistogramWithExemplar(httpDurationMetric, "Duration of HTTP requests in seconds",
+		c.durationBuckets, labels, duration, durationExemplar)
+	registry.ObserveHistogram(httpRequestSizeMetric, "Size of HTTP request bodies in bytes",
+		httpSizeBuckets, labels, float64(requestSize))
+	registry.ObserveHistogram(httpResponseSizeMetric, "Size of HTTP response bodies in bytes",
+		httpSizeBuckets, labels, float64(writer.size))
+}
+
+// Gets correlation id from query parameters or headers like RestService does
+func (c *HttpMetricsInterceptor) getCorrelationId(req *http.Request) string {
+	correlationId := req.URL.Query().Get("correlation_id")
+	if correlationId == "" {
+		correlationId = req.Header.Get("correlation_id")
+	}
+	return correlationId
+}
+
+// Counts bytes read from request body
+type httpMetricsBody struct {
+	io.ReadCloser
+	size int64
+}
+
+func (c *httpMetricsBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.size += int64(n)
+	return n, err
+}
+
+// Captures response status and counts written bytes
+type httpMetricsResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+func (c *httpMetricsResponseWriter) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.status = status
+		c.wroteHeader = true
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *httpMetricsResponseWriter) Write(data []byte) (int, error) {
+	c.wroteHeader = true
+	n, err := c.ResponseWriter.Write(data)
+	c.size += int64(n)
+	return n, err
+}
+
+func (c *httpMetricsResponseWriter) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
+)
+
+/*
+IDeregisterableDiscovery is an optional interface for IDiscovery services that can remove
+registered connections. Metrics services deregister their endpoints on close only from
+discovery services that implement it, others like MemoryDiscovery keep the connections
+until they are restarted.
+*/
+type IDeregisterableDiscovery interface {
+	// Deregister removes a connection registered under the given key.
+	//   - correlationId    (optional) transaction id to trace execution through call chain.
+	//   - key              a key that the connection was registered with.
+	//   - connection       the registered connection.
+	// Returns error or nil, if no errors occured.
+	Deregister(correlationId string, key string, connection *cconnect.ConnectionParams) error
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cauth "github.com/pip-services3-go/pip-services3-components-go/auth"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+)
+
+// Name of the metric with number of rejected scrapes
+const scrapeRejectedMetric = "prometheus_metrics_scrape_rejected_total"
+
+// metricsAccessControl restricts access to metrics with basic authentication,
+// bearer token and a list of allowed client networks.
+//
+// Configuration parameters:
+//   - credential:
+//     - store_key:        (optional) a key to retrieve the credentials from ICredentialStore
+//     - username:         user name for basic authentication
+//     - password:         user password for basic authentication
+//     - access_key:       bearer token
+//   - options:
+//     - allowed_networks: comma-separated list of client IP addresses or CIDR networks
+type metricsAccessControl struct {
+	credentialResolver *cauth.CredentialResolver
+	allowedNetworks    string
+	networks           []*net.IPNet
+	username           string
+	password           string
+	token              string
+}
+
+func newMetricsAccessControl() *metricsAccessControl {
+	return &metricsAccessControl{
+		credentialResolver: cauth.NewEmptyCredentialResolver(),
+	}
+}
+
+func (c *metricsAccessControl) Configure(config *cconf.ConfigParams) {
+	c.credentialResolver.Configure(config)
+	c.allowedNetworks = config.GetAsStringWithDefault("options.allowed_networks", c.allowedNetworks)
+}
+
+func (c *metricsAccessControl) SetReferences(references cref.IReferences) {
+	c.credentialResolver.SetReferences(references)
+}
+
+// Resolves credentials and parses allowed networks
+func (c *metricsAccessControl) Open(correlationId string) error {
+	credential, err := c.credentialResolver.Lookup(correlationId)
+	if err != nil {
+		return err
+	}
+	if credential != nil {
+		c.username = credential.Username()
+		c.password = credential.Password()
+		c.token = credential.AccessKey()
+	}
+
+	c.networks = make([]*net.IPNet, 0)
+	for _, item := range strings.Split(c.allowedNetworks, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			if strings.Contains(item, ":") {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return cerr.NewConfigError(correlationId, "INVALID_NETWORK", "Allowed network "+item+" is invalid").
+				WithDetails("network", item).WithCause(err)
+		}
+		c.networks = append(c.networks, network)
+	}
+
+	return nil
+}
+
+// Checks access to metrics. When access is denied it sends 401 or 403 response
+// and counts the rejected request in the registry.
+// Returns true if the request is allowed.
+func (c *metricsAccessControl) Authorize(res http.ResponseWriter, req *http.Request,
+	registry *pcount.PrometheusMetricsRegistry) bool {
+	if !c.isNetworkAllowed(req) {
+		registry.AddCounter(scrapeRejectedMetric, "Total number of rejected scrapes by reason",
+			map[string]string{"reason": "forbidden"}, 1)
+		http.Error(res, "Access to metrics from this address is forbidden", http.StatusForbidden)
+		return false
+	}
+
+	if !c.isAuthenticated(req) {
+		registry.AddCounter(scrapeRejectedMetric, "Total number of rejected scrapes by reason",
+			map[string]string{"reason": "unauthorized"}, 1)
+		if c.username != "" {
+			res.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		} else {
+			res.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		}
+		http.Error(res, "Access to metrics requires authentication", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+func (c *metricsAccessControl) isNetworkAllowed(req *http.Request) bool {
+	if len(c.networks) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range c.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts request with valid basic credentials or bearer token when any of them is configured
+func (c *metricsAccessControl) isAuthenticated(req *http.Request) bool {
+	if c.username == "" && c.token == "" {
+		return true
+	}
+
+	if c.username != "" {
+		username, password, ok := req.BasicAuth()
+		if ok && c.equal(username, c.username) && c.equal(password, c.password) {
+			return true
+		}
+	}
+
+	if c.token != "" {
+		header := req.Header.Get("Authorization")
+		if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") && c.equal(strings.TrimSpace(header[7:]), c.token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Compares strings in constant time to prevent timing attacks
+func (c *metricsAccessControl) equal(value1 string, value2 string) bool {
+	return subtle.ConstantTimeCompare([]byte(value1), []byte(value2)) == 1
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"reflect"
+	"strconv"
+
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+)
+
+// metricsCountersSource is a counters component exposed by PrometheusMetricsHandler.
+type metricsCountersSource struct {
+	name       string
+	counters   pcount.ICountersReader
+	prometheus *pcount.PrometheusCounters
+}
+
+// Collects counters given to the handler, counters found in references and extra counters.
+// Counters that can't be read like NullCounters or CompositeCounters are skipped.
+// Referenced sources are named after their descriptor kind and name, for instance "prometheus" or "cached:backup".
+func (c *PrometheusMetricsHandler) resolveCountersSources(references cref.IReferences,
+	extra ...pcount.ICountersReader) []*metricsCountersSource {
+	sources := make([]*metricsCountersSource, 0)
+	names := make(map[string]bool)
+
+	add := func(name string, component interface{}) {
+		reader, ok := component.(pcount.ICountersReader)
+		if !ok || reader == nil {
+			return
+		}
+		for _, source := range sources {
+			if c.sameComponent(source.counters, reader) {
+				return
+			}
+		}
+
+		if names[name] {
+			name += "_" + strconv.Itoa(len(sources))
+		}
+		names[name] = true
+
+		source := &metricsCountersSource{name: name, counters: reader}
+		source.prometheus, _ = component.(*pcount.PrometheusCounters)
+		sources = append(sources, source)
+	}
+
+	for _, counters := range c.counters {
+		add(c.defaultSourceName(counters), counters)
+	}
+
+	if references == nil {
+		return sources
+	}
+
+	// Components are looked up by their locators, since lists of all locators
+	// and all components are not guaranteed to match by index
+	locator := cref.NewDescriptor("*", "counters", "*", "*", "1.0")
+	for _, ref := range references.GetAllLocators() {
+		descriptor, ok := ref.(*cref.Descriptor)
+		if !ok || !locator.Match(descriptor) {
+			continue
+		}
+
+		name := descriptor.Kind()
+		if descriptor.Name() != "" && descriptor.Name() != "*" && descriptor.Name() != "default" {
+			name += ":" + descriptor.Name()
+		}
+		for _, component := range references.GetOptional(descriptor) {
+			add(name, component)
+		}
+	}
+
+	for _, counters := range extra {
+		add(c.defaultSourceName(counters), counters)
+	}
+
+	return sources
+}
+
+func (c *PrometheusMetricsHandler) defaultSourceName(counters pcount.ICountersReader) string {
+	if _, ok := counters.(*pcount.PrometheusCounters); ok {
+		return "prometheus"
+	}
+	return "cached"
+}
+
+// Compares components by identity without panics on uncomparable types
+func (c *PrometheusMetricsHandler) sameComponent(component1 interface{}, component2 interface{}) bool {
+	type1 := reflect.TypeOf(component1)
+	if type1 != reflect.TypeOf(component2) || type1 == nil || !type1.Comparable() {
+		return false
+	}
+	return component1 == component2
+}
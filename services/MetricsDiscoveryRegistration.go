@@ -0,0 +1,180 @@
+package services
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
+	clog "github.com/pip-services3-go/pip-services3-components-go/log"
+)
+
+// metricsDiscoveryRegistration announces where metrics are served by registering
+// the endpoint in all referenced IDiscovery services.
+//
+// Registered connections have protocol, host, port and uri of the metrics route
+// and labels.source, labels.instance, labels.scheme, labels.path with other configured labels.
+//
+// Configuration parameters:
+//   - registration:
+//     - discovery_key:    a key to register the endpoint, empty to disable registration
+//     - host:             (optional) host name to register instead of the resolved one
+//     - port:             (optional) port to register instead of the resolved one
+//     - labels:           (optional) additional labels to register
+type metricsDiscoveryRegistration struct {
+	logger      *clog.CompositeLogger
+	key         string
+	host        string
+	port        int
+	labels      map[string]string
+	discoveries []cconnect.IDiscovery
+	registered  *cconnect.ConnectionParams
+}
+
+func newMetricsDiscoveryRegistration(logger *clog.CompositeLogger) *metricsDiscoveryRegistration {
+	return &metricsDiscoveryRegistration{
+		logger:      logger,
+		labels:      make(map[string]string),
+		discoveries: make([]cconnect.IDiscovery, 0),
+	}
+}
+
+func (c *metricsDiscoveryRegistration) Configure(config *cconf.ConfigParams) {
+	c.key = config.GetAsStringWithDefault("registration.discovery_key", c.key)
+	c.host = config.GetAsStringWithDefault("registration.host", c.host)
+	c.port = config.GetAsIntegerWithDefault("registration.port", c.port)
+
+	labels := config.GetSection("registration.labels")
+	for _, name := range labels.Keys() {
+		c.labels[name] = labels.GetAsString(name)
+	}
+}
+
+func (c *metricsDiscoveryRegistration) SetReferences(references cref.IReferences) {
+	c.discoveries = make([]cconnect.IDiscovery, 0)
+	refs := references.GetOptional(cref.NewDescriptor("*", "discovery", "*", "*", "1.0"))
+	for _, ref := range refs {
+		if discovery, ok := ref.(cconnect.IDiscovery); ok {
+			c.discoveries = append(c.discoveries, discovery)
+		}
+	}
+}
+
+// Register registers the metrics endpoint in all discovery services.
+//   - connection    a resolved connection of the endpoint, can be nil when host and port are configured.
+//   - source        a source label.
+//   - instance      an instance label.
+//   - path          a path of metrics route.
+func (c *metricsDiscoveryRegistration) Register(correlationId string, connection *cconnect.ConnectionParams,
+	source string, instance string, path string) error {
+	if c.key == "" || len(c.discoveries) == 0 {
+		return nil
+	}
+
+	scheme := "http"
+	host := c.host
+	port := c.port
+	if connection != nil {
+		if connection.Protocol() != "" {
+			scheme = strings.ToLower(connection.Protocol())
+		}
+		if host == "" {
+			host = connection.Host()
+		}
+		if port == 0 {
+			port = connection.Port()
+		}
+	}
+
+	if port == 0 {
+		return cerr.NewConfigError(correlationId, "NO_PORT", "Port to register metrics endpoint is not set").
+			WithDetails("discovery_key", c.key)
+	}
+
+	// Wildcard addresses can't be scraped, so the host name is registered instead
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host, _ = os.Hostname()
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	registered := cconnect.NewEmptyConnectionParams()
+	registered.SetProtocol(scheme)
+	registered.SetHost(host)
+	registered.SetPort(port)
+	registered.SetUri(scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + path)
+	for name, value := range c.labels {
+		registered.Put("labels."+name, value)
+	}
+	registered.Put("labels.source", source)
+	registered.Put("labels.instance", instance)
+	registered.Put("labels.scheme", scheme)
+	registered.Put("labels.path", path)
+
+	for index, discovery := range c.discoveries {
+		_, err := discovery.Register(correlationId, c.key, registered)
+		if err != nil {
+			// Services that already registered the endpoint would announce it after failed open
+			rollbackErr := c.deregister(correlationId, c.discoveries[:index], registered)
+			if rollbackErr != nil {
+				c.logger.Error(correlationId, rollbackErr, "Failed to roll back registration of metrics endpoint")
+			}
+			return err
+		}
+	}
+
+	c.registered = registered
+	c.logger.Debug(correlationId, "Registered metrics endpoint %s under discovery key %s", registered.Uri(), c.key)
+	return nil
+}
+
+// Deregister removes the registered endpoint from discovery services that support it.
+// All services are tried even if some of them fail.
+func (c *metricsDiscoveryRegistration) Deregister(correlationId string) error {
+	if c.registered == nil {
+		return nil
+	}
+
+	registered := c.registered
+	c.registered = nil
+	return c.deregister(correlationId, c.discoveries, registered)
+}
+
+// Removes the endpoint from the discovery services and combines their errors into one
+func (c *metricsDiscoveryRegistration) deregister(correlationId string, discoveries []cconnect.IDiscovery,
+	registered *cconnect.ConnectionParams) error {
+	var causes []error
+	for _, discovery := range discoveries {
+		deregisterable, ok := discovery.(IDeregisterableDiscovery)
+		if !ok {
+			c.logger.Warn(correlationId, "Discovery service can't deregister metrics endpoint %s, it stays registered", registered.Uri())
+			continue
+		}
+		err := deregisterable.Deregister(correlationId, c.key, registered)
+		if err != nil {
+			causes = append(causes, err)
+		}
+	}
+
+	if len(causes) == 0 {
+		return nil
+	}
+	if len(causes) == 1 {
+		return causes[0]
+	}
+
+	messages := make([]string, len(causes))
+	for index, cause := range causes {
+		messages[index] = cause.Error()
+	}
+	return cerr.NewInvocationError(correlationId, "DEREGISTER_FAILED",
+		"Failed to deregister metrics endpoint from "+strconv.Itoa(len(causes))+" discovery services").
+		WithDetails("uri", registered.Uri()).
+		WithDetails("errors", strings.Join(messages, "; ")).
+		WithCause(causes[0])
+}
@@ -0,0 +1,433 @@
+package services
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	clog "github.com/pip-services3-go/pip-services3-components-go/log"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+)
+
+// Names of metrics about pushes added to every group like in Pushgateway
+const (
+	pushTimeMetric        = "push_time_seconds"
+	pushFailureTimeMetric = "push_failure_time_seconds"
+)
+
+// metricsPushGroup is a group of pushed metrics identified by grouping labels
+type metricsPushGroup struct {
+	labels             map[string]string
+	families           map[string]*pcount.PrometheusMetricFamily
+	pushTime           time.Time
+	failureTime        time.Time
+	lastPushSuccessful bool
+}
+
+// A group saved into the persistence file with metrics in text exposition format
+type metricsPushGroupFile struct {
+	Labels             map[string]string `json:"labels"`
+	Metrics            string            `json:"metrics"`
+	PushTime           int64             `json:"push_time"`
+	FailureTime        int64             `json:"failure_time"`
+	LastPushSuccessful bool              `json:"last_push_successful"`
+}
+
+// metricsPushStore keeps groups of pushed metrics in memory and optionally persists them
+// into a file, which is loaded on open and written periodically and on close.
+//
+// Configuration parameters:
+//   - persistence:
+//     - path:             (optional) path of the file to persist pushed metrics, empty to keep them only in memory
+//     - interval:         interval in milliseconds to write changed metrics into the file (default: 5 min)
+type metricsPushStore struct {
+	lock     sync.Mutex
+	logger   *clog.CompositeLogger
+	groups   map[string]*metricsPushGroup
+	path     string
+	interval int64
+	dirty    bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newMetricsPushStore(logger *clog.CompositeLogger) *metricsPushStore {
+	return &metricsPushStore{
+		logger:   logger,
+		groups:   make(map[string]*metricsPushGroup),
+		interval: 300000,
+	}
+}
+
+func (c *metricsPushStore) Configure(config *cconf.ConfigParams) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.path = config.GetAsStringWithDefault("persistence.path", c.path)
+	c.interval = config.GetAsLongWithDefault("persistence.interval", c.interval)
+}
+
+// Open loads persisted groups and starts writing changes periodically
+func (c *metricsPushStore) Open(correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.path == "" || c.done != nil {
+		return nil
+	}
+
+	err := c.load(correlationId)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	c.done = done
+	interval := time.Duration(c.interval) * time.Millisecond
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.Save(correlationId); err != nil {
+					c.logger.Error(correlationId, err, "Failed to persist pushed metrics")
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops periodic writing and writes the latest changes
+func (c *metricsPushStore) Close(correlationId string) error {
+	c.lock.Lock()
+	done := c.done
+	c.done = nil
+	c.lock.Unlock()
+
+	if done == nil {
+		return nil
+	}
+	close(done)
+	c.wg.Wait()
+
+	return c.Save(correlationId)
+}
+
+// Put stores pushed families in the group. With replace all metrics of the group are replaced,
+// otherwise only families with the same names. Grouping labels are added to all samples.
+// Returns BadRequestError when the metrics are inconsistent with grouping labels or other groups.
+func (c *metricsPushStore) Put(correlationId string, labels map[string]string,
+	families []*pcount.PrometheusMetricFamily, replace bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := (&pcount.PrometheusSample{Labels: labels}).Key()
+	group := c.groups[key]
+
+	err := c.validate(correlationId, key, labels, families)
+	if err != nil {
+		if group != nil {
+			group.failureTime = time.Now()
+			group.lastPushSuccessful = false
+			c.dirty = true
+		}
+		return err
+	}
+
+	if group == nil {
+		group = &metricsPushGroup{labels: labels}
+		c.groups[key] = group
+	}
+	if replace || group.families == nil {
+		group.families = make(map[string]*pcount.PrometheusMetricFamily)
+	}
+	for _, family := range families {
+		group.families[family.Name] = family
+	}
+	group.pushTime = time.Now()
+	group.lastPushSuccessful = true
+	c.dirty = true
+	return nil
+}
+
+// RecordFailure marks the last push into an existing group as failed
+func (c *metricsPushStore) RecordFailure(labels map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if group, ok := c.groups[(&pcount.PrometheusSample{Labels: labels}).Key()]; ok {
+		group.failureTime = time.Now()
+		group.lastPushSuccessful = false
+		c.dirty = true
+	}
+}
+
+// Delete removes the group with the given grouping labels
+func (c *metricsPushStore) Delete(labels map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := (&pcount.PrometheusSample{Labels: labels}).Key()
+	if _, ok := c.groups[key]; ok {
+		delete(c.groups, key)
+		c.dirty = true
+	}
+}
+
+// Groups returns copies of all groups sorted by grouping labels
+func (c *metricsPushStore) Groups() []*metricsPushGroup {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keys := make([]string, 0, len(c.groups))
+	for key := range c.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]*metricsPushGroup, 0, len(keys))
+	for _, key := range keys {
+		group := *c.groups[key]
+		families := make(map[string]*pcount.PrometheusMetricFamily, len(group.families))
+		for name, family := range group.families {
+			families[name] = family
+		}
+		group.families = families
+		result = append(result, &group)
+	}
+	return result
+}
+
+// Collect sends metrics of all groups with push_time_seconds and push_failure_time_seconds of each group
+func (c *metricsPushStore) Collect(ch chan<- *pcount.PrometheusMetricFamily) {
+	groups := c.Groups()
+	if len(groups) == 0 {
+		return
+	}
+
+	pushTime := pcount.NewPrometheusMetricFamily(pushTimeMetric, pcount.PrometheusGauge,
+		"Last Unix time when changing this group in the Pushgateway succeeded.")
+	failureTime := pcount.NewPrometheusMetricFamily(pushFailureTimeMetric, pcount.PrometheusGauge,
+		"Last Unix time when changing this group in the Pushgateway failed.")
+
+	for _, group := range groups {
+		pushTime.AddSample(pushTimeMetric, group.labels, c.seconds(group.pushTime))
+		failureTime.AddSample(pushFailureTimeMetric, group.labels, c.seconds(group.failureTime))
+
+		for _, name := range c.familyNames(group) {
+			family := group.families[name]
+			// Samples are copied, so handlers that add labels don't change stored metrics
+			copied := pcount.NewPrometheusMetricFamily(family.Name, family.Type, family.Help)
+			for _, sample := range family.Samples {
+				sampleLabels := make(map[string]string, len(sample.Labels))
+				for key, value := range sample.Labels {
+					sampleLabels[key] = value
+				}
+				copied.AddSample(sample.Name, sampleLabels, sample.Value)
+			}
+			ch <- copied
+		}
+	}
+
+	ch <- pushTime
+	ch <- failureTime
+}
+
+// Save writes groups into the persistence file when they changed since the last write
+func (c *metricsPushStore) Save(correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+
+	keys := make([]string, 0, len(c.groups))
+	for key := range c.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	items := make([]*metricsPushGroupFile, 0, len(keys))
+	for _, key := range keys {
+		group := c.groups[key]
+		families := make([]*pcount.PrometheusMetricFamily, 0, len(group.families))
+		for _, name := range c.familyNames(group) {
+			families = append(families, group.families[name])
+		}
+		items = append(items, &metricsPushGroupFile{
+			Labels:             group.labels,
+			Metrics:            pcount.PrometheusCounterConverter.FamiliesToString(families),
+			PushTime:           c.milliseconds(group.pushTime),
+			FailureTime:        c.milliseconds(group.failureTime),
+			LastPushSuccessful: group.lastPushSuccessful,
+		})
+	}
+
+	data, err := json.Marshal(items)
+	if err == nil {
+		err = c.write(data)
+	}
+	if err != nil {
+		return cerr.NewFileError(correlationId, "PERSISTENCE_FAILED", "Failed to persist pushed metrics").
+			WithDetails("path", c.path).WithCause(err)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// Replaces the persistence file atomically like textfile of PrometheusCounters
+func (c *metricsPushStore) write(data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(c.path), "."+filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, c.path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+	return err
+}
+
+// Reads groups from the persistence file, a missing file is not an error
+func (c *metricsPushStore) load(correlationId string) error {
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	items := make([]*metricsPushGroupFile, 0)
+	if err == nil {
+		err = json.Unmarshal(data, &items)
+	}
+	if err != nil {
+		return cerr.NewFileError(correlationId, "PERSISTENCE_FAILED", "Failed to read persisted pushed metrics").
+			WithDetails("path", c.path).WithCause(err)
+	}
+
+	groups := make(map[string]*metricsPushGroup, len(items))
+	for _, item := range items {
+		families, err := pcount.PrometheusCounterParser.ParseText(correlationId, item.Metrics)
+		if err != nil {
+			return cerr.NewFileError(correlationId, "PERSISTENCE_FAILED", "Failed to read persisted pushed metrics").
+				WithDetails("path", c.path).WithCause(err)
+		}
+
+		group := &metricsPushGroup{
+			labels:             item.Labels,
+			families:           make(map[string]*pcount.PrometheusMetricFamily, len(families)),
+			pushTime:           c.time(item.PushTime),
+			failureTime:        c.time(item.FailureTime),
+			lastPushSuccessful: item.LastPushSuccessful,
+		}
+		for _, family := range families {
+			group.families[family.Name] = family
+		}
+		groups[(&pcount.PrometheusSample{Labels: item.Labels}).Key()] = group
+	}
+
+	c.groups = groups
+	c.logger.Debug(correlationId, "Loaded %d groups of pushed metrics from %s", len(groups), c.path)
+	return nil
+}
+
+// Checks pushed families and adds grouping labels to their samples
+func (c *metricsPushStore) validate(correlationId string, key string, labels map[string]string,
+	families []*pcount.PrometheusMetricFamily) error {
+	for _, family := range families {
+		if family.Name == pushTimeMetric || family.Name == pushFailureTimeMetric {
+			return cerr.NewBadRequestError(correlationId, "INVALID_PUSH", "Metric "+family.Name+" is reserved").
+				WithDetails("metric", family.Name)
+		}
+
+		// Metrics of all groups are exposed together, so types must match
+		for groupKey, group := range c.groups {
+			if other, ok := group.families[family.Name]; ok && groupKey != key && other.Type != family.Type {
+				return cerr.NewBadRequestError(correlationId, "INVALID_PUSH",
+					"Metric "+family.Name+" has type "+other.Type+" in another group").
+					WithDetails("metric", family.Name).WithDetails("type", family.Type)
+			}
+		}
+
+		for _, sample := range family.Samples {
+			if sample.Timestamp != 0 {
+				return cerr.NewBadRequestError(correlationId, "INVALID_PUSH",
+					"Pushed metrics must not have timestamps").WithDetails("metric", sample.Name)
+			}
+
+			if sample.Labels == nil {
+				sample.Labels = make(map[string]string, len(labels))
+			}
+			for name, value := range labels {
+				if current, ok := sample.Labels[name]; ok && current != value {
+					return cerr.NewBadRequestError(correlationId, "INVALID_PUSH",
+						"Label "+name+" of metric "+sample.Name+" differs from grouping label").
+						WithDetails("metric", sample.Name).WithDetails("label", name)
+				}
+				sample.Labels[name] = value
+			}
+		}
+	}
+	return nil
+}
+
+func (c *metricsPushStore) familyNames(group *metricsPushGroup) []string {
+	names := make([]string, 0, len(group.families))
+	for name := range group.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *metricsPushStore) seconds(value time.Time) float64 {
+	if value.IsZero() {
+		return 0
+	}
+	return float64(value.UnixNano()) / 1e9
+}
+
+func (c *metricsPushStore) milliseconds(value time.Time) int64 {
+	if value.IsZero() {
+		return 0
+	}
+	return value.UnixNano() / int64(time.Millisecond)
+}
+
+func (c *metricsPushStore) time(value int64) time.Time {
+	if value == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, value*int64(time.Millisecond))
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+)
+
+// Names of metrics about scrape caching and coalescing
+const (
+	scrapeCacheHitsMetric   = "prometheus_metrics_scrape_cache_hits_total"
+	scrapeCacheMissesMetric = "prometheus_metrics_scrape_cache_misses_total"
+	scrapeCoalescedMetric   = "prometheus_metrics_scrape_coalesced_total"
+)
+
+// metricsScrapeCache coalesces concurrent scrapes with the same key into one collection
+// and optionally keeps encoded payloads for a short time.
+//
+// Configuration parameters:
+//   - options:
+//     - cache_ttl:        time in milliseconds to reuse an encoded payload, 0 to disable caching (default: 0)
+type metricsScrapeCache struct {
+	lock    sync.Mutex
+	ttl     int64
+	calls   map[string]*metricsScrapeCall
+	entries map[string]*metricsScrapeEntry
+}
+
+// A collection in progress that other scrapes wait for
+type metricsScrapeCall struct {
+	done    chan struct{}
+	payload []byte
+	ok      bool
+}
+
+type metricsScrapeEntry struct {
+	payload []byte
+	expires time.Time
+}
+
+func newMetricsScrapeCache() *metricsScrapeCache {
+	return &metricsScrapeCache{
+		calls:   make(map[string]*metricsScrapeCall),
+		entries: make(map[string]*metricsScrapeEntry),
+	}
+}
+
+func (c *metricsScrapeCache) Configure(config *cconf.ConfigParams) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ttl = config.GetAsLongWithDefault("options.cache_ttl", c.ttl)
+	if c.ttl <= 0 {
+		c.entries = make(map[string]*metricsScrapeEntry)
+	}
+}
+
+// Get returns a cached payload, waits for a collection already started for the same key
+// or starts a new one. Payloads that were not collected successfully are not cached.
+// The collection runs in background independently from the scrape that started it,
+// so every scrape waits for it until its own context is done and then returns false.
+// Hits, misses and coalesced scrapes are counted in the registry.
+func (c *metricsScrapeCache) Get(ctx context.Context, key string, registry *pcount.PrometheusMetricsRegistry,
+	collect func() ([]byte, bool)) ([]byte, bool) {
+	c.lock.Lock()
+
+	if entry, ok := c.entries[key]; ok {
+		if time.Now().Before(entry.expires) {
+			c.lock.Unlock()
+			registry.AddCounter(scrapeCacheHitsMetric, "Total number of scrapes served from cache", nil, 1)
+			return entry.payload, true
+		}
+		delete(c.entries, key)
+	}
+
+	call, ok := c.calls[key]
+	if ok {
+		c.lock.Unlock()
+		registry.AddCounter(scrapeCoalescedMetric, "Total number of scrapes that waited for a concurrent collection", nil, 1)
+	} else {
+		call = &metricsScrapeCall{done: make(chan struct{})}
+		c.calls[key] = call
+		caching := c.ttl > 0
+		c.lock.Unlock()
+
+		if caching {
+			registry.AddCounter(scrapeCacheMissesMetric, "Total number of scrapes not found in cache", nil, 1)
+		}
+		go c.run(key, call, collect)
+	}
+
+	select {
+	case <-call.done:
+		return call.payload, call.ok
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// Runs the collection and releases scrapes waiting for it
+func (c *metricsScrapeCache) run(key string, call *metricsScrapeCall, collect func() ([]byte, bool)) {
+	// Waiting scrapes must be released even if collection panics
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, key)
+		if call.ok && c.ttl > 0 {
+			c.entries[key] = &metricsScrapeEntry{
+				payload: call.payload,
+				expires: time.Now().Add(time.Duration(c.ttl) * time.Millisecond),
+			}
+		}
+		c.lock.Unlock()
+		close(call.done)
+	}()
+
+	call.payload, call.ok = collect()
+}
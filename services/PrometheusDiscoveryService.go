@@ -0,0 +1,274 @@
+package services
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
+	rpcservices "github.com/pip-services3-go/pip-services3-rpc-go/services"
+)
+
+/*
+PrometheusDiscoveryService is a service that exposes scrape targets for Prometheus
+in HTTP service discovery format (http_sd_config):
+
+    [{"targets": ["host1:8080", "host2:8080"], "labels": {"__metrics_path__": "/metrics", "source": "myservice"}}]
+
+Targets are built from connections registered in IDiscovery services under the configured keys,
+for instance by PrometheusMetricsService with registration.discovery_key, and from targets listed in configuration.
+A target is taken from host and port of a connection or from its uri. Connection parameters
+labels.* become target labels, labels.scheme and labels.path are mapped to __scheme__ and __metrics_path__.
+Targets with the same labels are grouped together.
+
+Configuration parameters:
+
+  - route:                   route to expose targets (default: targets)
+  - discovery_keys:          (optional) comma-separated keys to resolve connections from IDiscovery services
+  - targets:                 (optional) static targets by names
+    - <name>:
+      - protocol:            connection protocol: http or https
+      - host:                host name or IP address
+      - port:                port number
+      - uri:                 resource URI of metrics route
+      - labels:              (optional) target labels
+  - dependencies:
+    - endpoint:              override for HTTP Endpoint dependency
+  - connection(s):
+    - discovery_key:         (optional) a key to retrieve the connection from IDiscovery
+    - protocol:              connection protocol: http or https
+    - host:                  host name or IP address
+    - port:                  port number
+    - uri:                   resource URI or connection string with all parameters in it
+
+References:
+
+- *:logger:*:*:1.0               (optional)  ILogger components to pass log messages
+- *:discovery:*:*:1.0            (optional)  IDiscovery services to resolve connections and targets
+- *:endpoint:http:*:1.0          (optional)  HttpEndpoint reference to expose REST operation
+
+Example:
+
+    service := NewPrometheusDiscoveryService()
+    service.Configure(cconf.NewConfigParamsFromTuples(
+        "connection.protocol", "http",
+        "connection.host", "localhost",
+        "connection.port", 8080,
+        "discovery_keys", "metrics",
+        "targets.gateway.uri", "http://pushgateway:9091/metrics",
+    ))
+
+    err := service.Open("123")
+    if err == nil {
+        fmt.Println("Prometheus can discover targets at http://localhost:8080/targets")
+        defer service.Close("")
+    }
+*/
+type PrometheusDiscoveryService struct {
+	rpcservices.RestService
+	route         string
+	discoveryKeys []string
+	targets       []*cconnect.ConnectionParams
+	discoveries   []cconnect.IDiscovery
+}
+
+// PrometheusTargetGroup is a group of targets with the same labels in http_sd_config format
+type PrometheusTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// NewPrometheusDiscoveryService creates a new instance of the service.
+// Returns *PrometheusDiscoveryService
+// pointer on new instance
+func NewPrometheusDiscoveryService() *PrometheusDiscoveryService {
+	c := &PrometheusDiscoveryService{}
+	c.RestService = *rpcservices.InheritRestService(c)
+	c.route = "targets"
+	c.discoveryKeys = make([]string, 0)
+	c.targets = make([]*cconnect.ConnectionParams, 0)
+	c.discoveries = make([]cconnect.IDiscovery, 0)
+	return c
+}
+
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+//   - config *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *PrometheusDiscoveryService) Configure(config *cconf.ConfigParams) {
+	c.RestService.Configure(config)
+
+	c.route = config.GetAsStringWithDefault("route", c.route)
+
+	keys := config.GetAsStringWithDefault("discovery_keys", "")
+	if keys != "" {
+		c.discoveryKeys = make([]string, 0)
+		for _, key := range strings.Split(keys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				c.discoveryKeys = append(c.discoveryKeys, key)
+			}
+		}
+	}
+
+	targets := config.GetSection("targets")
+	if len(targets.Keys()) > 0 {
+		names := make(map[string]bool)
+		for _, key := range targets.Keys() {
+			names[strings.Split(key, ".")[0]] = true
+		}
+
+		c.targets = make([]*cconnect.ConnectionParams, 0, len(names))
+		for name := range names {
+			c.targets = append(c.targets, cconnect.NewConnectionParams(targets.GetSection(name).Value()))
+		}
+	}
+}
+
+// SetReferences is sets references to dependent components.
+// Parameters:
+//   - references cref.IReferences
+// references to locate the component dependencies.
+func (c *PrometheusDiscoveryService) SetReferences(references cref.IReferences) {
+	c.RestService.SetReferences(references)
+
+	c.discoveries = make([]cconnect.IDiscovery, 0)
+	refs := references.GetOptional(cref.NewDescriptor("*", "discovery", "*", "*", "1.0"))
+	for _, ref := range refs {
+		if discovery, ok := ref.(cconnect.IDiscovery); ok {
+			c.discoveries = append(c.discoveries, discovery)
+		}
+	}
+}
+
+// Register method are registers all service routes in HTTP endpoint.
+func (c *PrometheusDiscoveryService) Register() {
+	c.RegisterRoute("get", c.route, nil, c.targetsHandler)
+}
+
+func (c *PrometheusDiscoveryService) targetsHandler(res http.ResponseWriter, req *http.Request) {
+	correlationId := c.GetCorrelationId(req)
+
+	groups, err := c.TargetGroups(correlationId)
+	c.SendResult(res, req, groups, err)
+}
+
+// TargetGroups method collects targets from discovery services and configuration
+// and groups them by labels.
+//   - correlationId    (optional) transaction id to trace execution through call chain.
+// Returns []*PrometheusTargetGroup, error
+// target groups sorted by their first targets or error if discovery services failed.
+func (c *PrometheusDiscoveryService) TargetGroups(correlationId string) ([]*PrometheusTargetGroup, error) {
+	connections := make([]*cconnect.ConnectionParams, 0)
+	connections = append(connections, c.targets...)
+
+	for _, key := range c.discoveryKeys {
+		for _, discovery := range c.discoveries {
+			resolved, err := discovery.ResolveAll(correlationId, key)
+			if err != nil {
+				return nil, err
+			}
+			connections = append(connections, resolved...)
+		}
+	}
+
+	groups := make([]*PrometheusTargetGroup, 0)
+	groupsByLabels := make(map[string]*PrometheusTargetGroup)
+	seen := make(map[string]bool)
+
+	for _, connection := range connections {
+		if connection == nil {
+			continue
+		}
+		target, labels := c.toTarget(connection)
+		if target == "" {
+			continue
+		}
+
+		key := c.labelsKey(labels)
+		if seen[key+"|"+target] {
+			continue
+		}
+		seen[key+"|"+target] = true
+
+		group, ok := groupsByLabels[key]
+		if !ok {
+			group = &PrometheusTargetGroup{Targets: make([]string, 0), Labels: labels}
+			groupsByLabels[key] = group
+			groups = append(groups, group)
+		}
+		group.Targets = append(group.Targets, target)
+	}
+
+	for _, group := range groups {
+		sort.Strings(group.Targets)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Targets[0] < groups[j].Targets[0] })
+	return groups, nil
+}
+
+// Converts a connection into host:port target and its labels
+func (c *PrometheusDiscoveryService) toTarget(connection *cconnect.ConnectionParams) (string, map[string]string) {
+	labels := make(map[string]string)
+	section := connection.GetSection("labels")
+	for _, name := range section.Keys() {
+		labels[name] = section.GetAsString(name)
+	}
+
+	scheme := labels["scheme"]
+	path := labels["path"]
+	delete(labels, "scheme")
+	delete(labels, "path")
+
+	target := ""
+	if connection.Host() != "" && connection.Port() > 0 {
+		target = net.JoinHostPort(connection.Host(), strconv.Itoa(connection.Port()))
+		if scheme == "" {
+			scheme = strings.ToLower(connection.Protocol())
+		}
+	}
+
+	if connection.Uri() != "" {
+		if address, err := url.Parse(connection.Uri()); err == nil && address.Host != "" {
+			if target == "" {
+				target = address.Host
+			}
+			if scheme == "" {
+				scheme = address.Scheme
+			}
+			if path == "" {
+				path = address.Path
+			}
+		}
+	}
+
+	if scheme != "" {
+		labels["__scheme__"] = scheme
+	}
+	if path != "" {
+		labels["__metrics_path__"] = path
+	}
+	return target, labels
+}
+
+// Composes a key of labels sorted by names to find groups with the same labels
+func (c *PrometheusDiscoveryService) labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(strconv.Quote(name))
+		key.WriteString("=")
+		key.WriteString(strconv.Quote(labels[name]))
+		key.WriteString(",")
+	}
+	return key.String()
+}
@@ -0,0 +1,476 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cinfo "github.com/pip-services3-go/pip-services3-components-go/info"
+	clog "github.com/pip-services3-go/pip-services3-components-go/log"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+)
+
+/*
+PrometheusMetricsHandler is a plain http.Handler that serves metrics in Prometheus text format
+or in OpenMetrics format with exemplars when it is enabled and requested by the scraper.
+It can be mounted in any router: net/http, chi, gin and others. PrometheusMetricsService
+and PrometheusMetricsServer use it to handle scrapes.
+
+Metrics are collected from counters given to the constructor, counters and collectors found
+in references, collectors added by AddCollectors and the handler's own registry.
+When there are several counters sources, their metrics get "counters" label.
+
+Concurrent scrapes that request the same content are coalesced into one collection.
+Every scrape waits for it within its own scrape timeout, and a scrape that gives up doesn't cancel the collection for others.
+Encoded payloads can also be cached for a short time, so scrapes of several Prometheus replicas
+don't repeat the work. The handler counts cache hits, misses and coalesced scrapes in its registry.
+
+Configuration parameters:
+
+  - source:                  (optional) source label, context name by default
+  - instance:                (optional) instance label, context id by default
+  - options:
+    - compression:           compress responses with gzip or deflate when accepted by the scraper (default: true)
+    - openmetrics:           serve OpenMetrics format with exemplars when the scraper accepts it (default: false)
+    - cache_ttl:             time in milliseconds to reuse an encoded payload, 0 to disable caching (default: 0)
+    - allowed_networks:      (optional) comma-separated list of client IP addresses or CIDR networks allowed to scrape metrics
+    - runtime_metrics:       expose Go runtime metrics with built-in GoRuntimeCollector (default: false)
+    - process_metrics:       expose process metrics with built-in ProcessCollector (default: false)
+  - credential:              (optional) credentials required to scrape metrics
+    - store_key:             (optional) a key to retrieve the credentials from ICredentialStore
+    - username:              user name for basic authentication
+    - password:              user password for basic authentication
+    - access_key:            bearer token
+
+References:
+
+- *:logger:*:*:1.0               (optional)  ILogger components to pass log messages
+- *:counters:*:*:1.0             (optional)  Counters which measurements are exposed
+- *:credential-store:*:*:1.0     (optional)  Credential stores to resolve credentials
+- *:context-info:*:*:1.0         (optional)  Context info to get source and instance labels
+- *:metrics-collector:*:*:1.0    (optional)  ICollector components invoked on every scrape to compute metrics
+- *:metrics-relay:*:*:1.0        (optional)  ICollector components that relay metrics of other processes, like PrometheusScrapeCollector
+
+Relayed samples get source and instance labels of this service, their own values are kept
+in exported_source and exported_instance labels.
+
+When credentials are configured, Open method must be called to resolve them before serving requests.
+
+Example:
+
+    counters := pcount.NewPrometheusCounters()
+    handler := NewPrometheusMetricsHandler(counters)
+
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", handler)
+    mux.Handle("/federate", handler.FederateHandler())
+    http.ListenAndServe(":9100", mux)
+*/
+type PrometheusMetricsHandler struct {
+	logger               *clog.CompositeLogger
+	counters             []pcount.ICountersReader
+	sources              []*metricsCountersSource
+	collectors           []pcount.ICollector
+	referencedCollectors []pcount.ICollector
+	referencedRelays     []pcount.ICollector
+	runtimeCollector     *pcount.GoRuntimeCollector
+	processCollector     *pcount.ProcessCollector
+	source               string
+	instance             string
+	compression          bool
+	openMetrics          bool
+	runtimeMetrics       bool
+	processMetrics       bool
+	accessControl        *metricsAccessControl
+	cache                *metricsScrapeCache
+	registry             *pcount.PrometheusMetricsRegistry
+}
+
+// NewPrometheusMetricsHandler creates a new instance of the handler.
+//   - counters    counters which measurements are exposed.
+// Returns *PrometheusMetricsHandler
+// pointer on new instance
+func NewPrometheusMetricsHandler(counters ...pcount.ICountersReader) *PrometheusMetricsHandler {
+	c := &PrometheusMetricsHandler{
+		logger:           clog.NewCompositeLogger(),
+		counters:         counters,
+		collectors:       make([]pcount.ICollector, 0),
+		runtimeCollector: pcount.NewGoRuntimeCollector(),
+		processCollector: pcount.NewProcessCollector(),
+		compression:      true,
+		accessControl:    newMetricsAccessControl(),
+		cache:            newMetricsScrapeCache(),
+		registry:         pcount.NewPrometheusMetricsRegistry(),
+	}
+	c.sources = c.resolveCountersSources(nil)
+	return c
+}
+
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+//   - config *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *PrometheusMetricsHandler) Configure(config *cconf.ConfigParams) {
+	c.source = config.GetAsStringWithDefault("source", c.source)
+	c.instance = config.GetAsStringWithDefault("instance", c.instance)
+	c.compression = config.GetAsBooleanWithDefault("options.compression", c.compression)
+	c.openMetrics = config.GetAsBooleanWithDefault("options.openmetrics", c.openMetrics)
+	c.runtimeMetrics = config.GetAsBooleanWithDefault("options.runtime_metrics", c.runtimeMetrics)
+	c.processMetrics = config.GetAsBooleanWithDefault("options.process_metrics", c.processMetrics)
+	c.accessControl.Configure(config)
+	c.cache.Configure(config)
+}
+
+// SetReferences is sets references to dependent components.
+// Parameters:
+//   - references cref.IReferences
+// references to locate the component dependencies.
+func (c *PrometheusMetricsHandler) SetReferences(references cref.IReferences) {
+	c.logger.SetReferences(references)
+	c.accessControl.SetReferences(references)
+	c.sources = c.resolveCountersSources(references)
+
+	ref := references.GetOneOptional(
+		cref.NewDescriptor("pip-services", "context-info", "default", "*", "1.0"))
+	contextInfo, _ := ref.(*cinfo.ContextInfo)
+
+	if contextInfo != nil && c.source == "" {
+		c.source = contextInfo.Name
+	}
+	if contextInfo != nil && c.instance == "" {
+		c.instance = contextInfo.ContextId
+	}
+
+	c.referencedCollectors = make([]pcount.ICollector, 0)
+	refs := references.GetOptional(cref.NewDescriptor("*", "metrics-collector", "*", "*", "1.0"))
+	for _, ref := range refs {
+		if collector, ok := ref.(pcount.ICollector); ok {
+			c.referencedCollectors = append(c.referencedCollectors, collector)
+		}
+	}
+
+	c.referencedRelays = make([]pcount.ICollector, 0)
+	refs = references.GetOptional(cref.NewDescriptor("*", "metrics-relay", "*", "*", "1.0"))
+	for _, ref := range refs {
+		if collector, ok := ref.(pcount.ICollector); ok {
+			c.referencedRelays = append(c.referencedRelays, collector)
+		}
+	}
+}
+
+// Open method resolves credentials and allowed networks.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusMetricsHandler) Open(correlationId string) error {
+	return c.accessControl.Open(correlationId)
+}
+
+// AddCollectors method adds collectors invoked on every scrape.
+//   - collectors    collectors to add.
+func (c *PrometheusMetricsHandler) AddCollectors(collectors ...pcount.ICollector) {
+	c.collectors = append(c.collectors, collectors...)
+}
+
+// SetLabels method sets source and instance labels added to all metrics.
+//   - source      a source label.
+//   - instance    an instance label.
+func (c *PrometheusMetricsHandler) SetLabels(source string, instance string) {
+	c.source = source
+	c.instance = instance
+}
+
+// Registry method returns the registry of the handler's own metrics,
+// like number of rejected scrapes.
+// Returns *PrometheusMetricsRegistry
+func (c *PrometheusMetricsHandler) Registry() *pcount.PrometheusMetricsRegistry {
+	return c.registry
+}
+
+// ServeHTTP method handles scrape requests.
+//   - res   an HTTP response
+//   - req   an HTTP request
+func (c *PrometheusMetricsHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if !c.accessControl.Authorize(res, req, c.registry) {
+		return
+	}
+
+	c.notifyScrape()
+	c.sendMetrics(res, req, nil, "metrics")
+}
+
+// FederateHandler method returns a handler of federation requests
+// that returns only series matched by any of match[] selectors.
+// Returns http.Handler
+func (c *PrometheusMetricsHandler) FederateHandler() http.Handler {
+	return http.HandlerFunc(c.ServeFederate)
+}
+
+// ServeFederate method handles federation requests with match[] selectors.
+//   - res   an HTTP response
+//   - req   an HTTP request
+func (c *PrometheusMetricsHandler) ServeFederate(res http.ResponseWriter, req *http.Request) {
+	if !c.accessControl.Authorize(res, req, c.registry) {
+		return
+	}
+
+	selectors := make([]*pcount.PrometheusSeriesSelector, 0)
+	for _, match := range req.URL.Query()["match[]"] {
+		selector, err := pcount.ParsePrometheusSeriesSelector(match)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+		selectors = append(selectors, selector)
+	}
+
+	c.notifyScrape()
+	c.sendMetrics(res, req, selectors, "federate:"+strings.Join(req.URL.Query()["match[]"], "\n"))
+}
+
+// Tells PrometheusCounters that their metrics are scraped, so they don't need to push them in fallback mode
+func (c *PrometheusMetricsHandler) notifyScrape() {
+	for _, source := range c.sources {
+		if source.prometheus != nil {
+			source.prometheus.NotifyScrape()
+		}
+	}
+}
+
+// Returns referenced and added collectors together with enabled built-in ones
+func (c *PrometheusMetricsHandler) allCollectors() []pcount.ICollector {
+	collectors := make([]pcount.ICollector, 0, len(c.referencedCollectors)+len(c.collectors)+2)
+	collectors = append(collectors, c.referencedCollectors...)
+	collectors = append(collectors, c.collectors...)
+
+	hasRuntimeCollector := false
+	hasProcessCollector := false
+	for _, collector := range collectors {
+		if _, ok := collector.(*pcount.GoRuntimeCollector); ok {
+			hasRuntimeCollector = true
+		}
+		if _, ok := collector.(*pcount.ProcessCollector); ok {
+			hasProcessCollector = true
+		}
+	}
+
+	// Avoid duplicated metrics when built-in collectors are also added to references
+	if c.runtimeMetrics && !hasRuntimeCollector {
+		collectors = append(collectors, c.runtimeCollector)
+	}
+	if c.processMetrics && !hasProcessCollector {
+		collectors = append(collectors, c.processCollector)
+	}
+	return collectors
+}
+
+// Collects metric families from all sources, counters get exemplars for OpenMetrics format
+func (c *PrometheusMetricsHandler) collect(openMetrics bool) []*pcount.PrometheusMetricFamily {
+	labels := map[string]string{"source": c.source, "instance": c.instance}
+
+	families := make([]*pcount.PrometheusMetricFamily, 0)
+	for _, source := range c.sources {
+		var sourceFamilies []*pcount.PrometheusMetricFamily
+		if openMetrics {
+			var exemplars map[string]*pcount.PrometheusExemplar
+			if source.prometheus != nil {
+				exemplars = source.prometheus.Exemplars()
+			}
+			sourceFamilies = pcount.PrometheusCounterConverter.ToMetricFamiliesWithExemplars(
+				source.counters.GetAll(), exemplars, c.source, c.instance)
+		} else {
+			sourceFamilies = pcount.PrometheusCounterConverter.ToMetricFamilies(
+				source.counters.GetAll(), c.source, c.instance)
+		}
+		if source.prometheus != nil {
+			sourceFamilies = append(sourceFamilies,
+				pcount.PrometheusCounterConverter.AddLabels(source.prometheus.RegistryFamilies(), labels)...)
+		}
+		// Counters from different sources are distinguished by the label only when there are several of them
+		if len(c.sources) > 1 {
+			sourceFamilies = pcount.PrometheusCounterConverter.AddLabels(sourceFamilies,
+				map[string]string{"counters": source.name})
+		}
+		families = append(families, sourceFamilies...)
+	}
+
+	collected := pcount.CollectMetricFamilies(c.allCollectors())
+	families = append(families, pcount.PrometheusCounterConverter.AddLabels(collected, labels)...)
+	// Relayed metrics belong to other processes, so their own source and instance are kept as exported labels
+	relayed := pcount.CollectMetricFamilies(c.referencedRelays)
+	families = append(families, pcount.PrometheusCounterConverter.ExportLabels(relayed, labels)...)
+	families = append(families, c.registry.Families()...)
+
+	return pcount.PrometheusCounterConverter.MergeFamilies(families)
+}
+
+// Returns scrape timeout given by Prometheus in X-Prometheus-Scrape-Timeout-Seconds header or 0 without timeout
+func (c *PrometheusMetricsHandler) scrapeTimeout(req *http.Request) time.Duration {
+	timeout, err := strconv.ParseFloat(req.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || timeout <= 0 {
+		return 0
+	}
+	return time.Duration(timeout * float64(time.Second))
+}
+
+// Collects metrics, filters them by selectors when they are not nil and writes them in the response.
+// Scrapes with the same key, format and encoding share collected payloads.
+func (c *PrometheusMetricsHandler) sendMetrics(res http.ResponseWriter, req *http.Request,
+	selectors []*pcount.PrometheusSeriesSelector, key string) {
+	openMetrics := c.openMetrics && c.acceptsOpenMetrics(req.Header.Get("Accept"))
+	contentType := "text/plain; version=0.0.4"
+	if openMetrics {
+		contentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	}
+
+	encoding := ""
+	if c.compression {
+		encoding = c.selectEncoding(req.Header.Get("Accept-Encoding"))
+	}
+
+	// Scrapes stop waiting when their clients disconnect or their scrape timeouts expire,
+	// but shared collections are completed for other scrapes
+	ctx := req.Context()
+	if timeout := c.scrapeTimeout(req); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	key = contentType + "|" + encoding + "|" + key
+	payload, ok := c.cache.Get(ctx, key, c.registry, func() ([]byte, bool) {
+		families := c.collect(openMetrics)
+		if selectors != nil {
+			families = pcount.FilterMetricFamilies(families, selectors)
+		}
+		return c.encodeMetrics(families, openMetrics, encoding)
+	})
+	if !ok {
+		http.Error(res, "Collecting metrics exceeded scrape timeout", http.StatusServiceUnavailable)
+		return
+	}
+
+	res.Header().Add("content-type", contentType)
+	res.Header().Add("vary", "Accept-Encoding")
+	if c.openMetrics {
+		res.Header().Add("vary", "Accept")
+	}
+	if encoding != "" {
+		res.Header().Add("content-encoding", encoding)
+	}
+	res.WriteHeader(200)
+
+	_, wrErr := res.Write(payload)
+	if wrErr != nil {
+		c.logger.Error("PrometheusMetricsHandler", wrErr, "Can't write response")
+	}
+}
+
+// Writes metric families in the requested format and compresses them with the given encoding
+func (c *PrometheusMetricsHandler) encodeMetrics(families []*pcount.PrometheusMetricFamily,
+	openMetrics bool, encoding string) ([]byte, bool) {
+	var body string
+	if openMetrics {
+		body = pcount.PrometheusCounterConverter.FamiliesToOpenMetrics(families)
+	} else {
+		body = pcount.PrometheusCounterConverter.FamiliesToString(families)
+	}
+
+	var buffer bytes.Buffer
+	var writer io.Writer = &buffer
+	var closer io.Closer
+	switch encoding {
+	case "gzip":
+		gzipWriter := gzip.NewWriter(&buffer)
+		writer, closer = gzipWriter, gzipWriter
+	case "deflate":
+		flateWriter, _ := flate.NewWriter(&buffer, flate.DefaultCompression)
+		writer, closer = flateWriter, flateWriter
+	}
+
+	_, err := io.WriteString(writer, body)
+	if err == nil && closer != nil {
+		err = closer.Close()
+	}
+	if err != nil {
+		c.logger.Error("PrometheusMetricsHandler", err, "Can't encode metrics")
+		return nil, false
+	}
+	return buffer.Bytes(), true
+}
+
+// Checks if OpenMetrics format is accepted and preferred over Prometheus text format according to Accept header
+func (c *PrometheusMetricsHandler) acceptsOpenMetrics(accept string) bool {
+	openMetricsQuality := 0.0
+	textQuality := 0.0
+
+	for _, item := range strings.Split(accept, ",") {
+		parts := strings.Split(item, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = value
+				}
+			}
+		}
+
+		switch mediaType {
+		case "application/openmetrics-text":
+			if quality > openMetricsQuality {
+				openMetricsQuality = quality
+			}
+		case "text/plain":
+			if quality > textQuality {
+				textQuality = quality
+			}
+		}
+	}
+
+	return openMetricsQuality > 0 && openMetricsQuality >= textQuality
+}
+
+// Selects gzip or deflate encoding accepted by the client according to Accept-Encoding header.
+// Returns empty string if response shall not be compressed.
+func (c *PrometheusMetricsHandler) selectEncoding(acceptEncoding string) string {
+	result := ""
+	resultQuality := 0.0
+
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(item, ";")
+		encoding := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = value
+				}
+			}
+		}
+
+		if encoding == "*" {
+			encoding = "gzip"
+		}
+		if (encoding == "gzip" || encoding == "deflate") && quality > resultQuality {
+			result = encoding
+			resultQuality = quality
+		}
+	}
+
+	return result
+}
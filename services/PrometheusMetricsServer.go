@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
+	clog "github.com/pip-services3-go/pip-services3-components-go/log"
+	rpcconnect "github.com/pip-services3-go/pip-services3-rpc-go/connect"
+)
+
+/*
+PrometheusMetricsServer is a lightweight server that exposes metrics for Prometheus on its own
+listener, usually on a dedicated management port separate from the application's main endpoint.
+Unlike PrometheusMetricsService it doesn't need HttpEndpoint and serves only metrics routes.
+
+Configuration parameters:
+
+  - route:                   route to expose metrics (default: metrics)
+  - federate_route:          route to expose selected metrics for federation (default: federate)
+  - options:
+    - federate:              expose federation route (default: false)
+    - shutdown_timeout:      time in milliseconds to complete active requests on close (default: 5 sec)
+  - registration:            (optional) registration of the metrics endpoint in IDiscovery, see PrometheusMetricsService
+    - other handler options (compression, allowed networks, runtime and process metrics): see PrometheusMetricsHandler
+  - credential:              (optional) credentials required to scrape metrics, see PrometheusMetricsHandler
+  - connection(s):
+    - discovery_key:         (optional) a key to retrieve the connection from IDiscovery
+    - protocol:              connection protocol: only http is supported
+    - host:                  host name or IP address (default: 0.0.0.0)
+    - port:                  port number
+    - uri:                   resource URI or connection string with all parameters in it
+
+References:
+
+- *:logger:*:*:1.0               (optional)  ILogger components to pass log messages
+- *:discovery:*:*:1.0            (optional)  IDiscovery services to resolve connection
+- *:credential-store:*:*:1.0     (optional)  Credential stores to resolve credentials
+- *:counters:*:*:1.0             (optional)  Counters which measurements are exposed
+- *:metrics-collector:*:*:1.0    (optional)  ICollector components invoked on every scrape to compute metrics
+- *:metrics-relay:*:*:1.0        (optional)  ICollector components that relay metrics of other processes
+
+Example:
+
+    server := NewPrometheusMetricsServer()
+    server.Configure(cconf.NewConfigParamsFromTuples(
+        "connection.protocol", "http",
+        "connection.port", 9100,
+    ))
+    server.SetReferences(references)
+
+    err := server.Open("123")
+    if err == nil {
+        fmt.Println("Metrics are accessible at http://localhost:9100/metrics")
+        defer server.Close("")
+    }
+*/
+type PrometheusMetricsServer struct {
+	logger             *clog.CompositeLogger
+	connectionResolver *rpcconnect.HttpConnectionResolver
+	handler            *PrometheusMetricsHandler
+	registration       *metricsDiscoveryRegistration
+	route              string
+	federate           bool
+	federateRoute      string
+	shutdownTimeout    int
+	server             *http.Server
+	listener           net.Listener
+	address            string
+	lock               sync.Mutex
+}
+
+// NewPrometheusMetricsServer creates a new instance of the server.
+// Returns *PrometheusMetricsServer
+// pointer on new instance
+func NewPrometheusMetricsServer() *PrometheusMetricsServer {
+	c := &PrometheusMetricsServer{
+		logger:             clog.NewCompositeLogger(),
+		connectionResolver: rpcconnect.NewHttpConnectionResolver(),
+		handler:            NewPrometheusMetricsHandler(),
+		route:              "metrics",
+		federateRoute:      "federate",
+		shutdownTimeout:    5000,
+	}
+	c.registration = newMetricsDiscoveryRegistration(c.logger)
+	return c
+}
+
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+//   - config *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *PrometheusMetricsServer) Configure(config *cconf.ConfigParams) {
+	c.connectionResolver.Configure(config)
+	c.handler.Configure(config)
+	c.registration.Configure(config)
+
+	c.route = config.GetAsStringWithDefault("route", c.route)
+	c.federateRoute = config.GetAsStringWithDefault("federate_route", c.federateRoute)
+	c.federate = config.GetAsBooleanWithDefault("options.federate", c.federate)
+	c.shutdownTimeout = config.GetAsIntegerWithDefault("options.shutdown_timeout", c.shutdownTimeout)
+}
+
+// SetReferences is sets references to dependent components.
+// Parameters:
+//   - references cref.IReferences
+// references to locate the component dependencies.
+func (c *PrometheusMetricsServer) SetReferences(references cref.IReferences) {
+	c.logger.SetReferences(references)
+	c.connectionResolver.SetReferences(references)
+	c.handler.SetReferences(references)
+	c.registration.SetReferences(references)
+}
+
+// Handler method returns the handler that serves scrapes of this server.
+// Returns *PrometheusMetricsHandler
+func (c *PrometheusMetricsServer) Handler() *PrometheusMetricsHandler {
+	return c.handler
+}
+
+// IsOpen method are checks if the component is opened.
+// Returns true if the component has been opened and false otherwise.
+func (c *PrometheusMetricsServer) IsOpen() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.server != nil
+}
+
+// Address method returns the address the server listens on, for instance 0.0.0.0:9100.
+// Returns empty string when the server is not opened.
+func (c *PrometheusMetricsServer) Address() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.address
+}
+
+// Open method are opens the component and starts listening.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusMetricsServer) Open(correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.server != nil {
+		return nil
+	}
+
+	connection, _, err := c.connectionResolver.Resolve(correlationId)
+	if err != nil {
+		return err
+	}
+	if connection.Protocol() != "" && strings.ToLower(connection.Protocol()) != "http" {
+		return cerr.NewConfigError(correlationId, "UNSUPPORTED_PROTOCOL",
+			"Metrics server supports only http protocol").WithDetails("protocol", connection.Protocol())
+	}
+
+	err = c.handler.Open(correlationId)
+	if err != nil {
+		return err
+	}
+
+	host := connection.Host()
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(connection.Port()))
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return cerr.NewConnectionError(correlationId, "CANNOT_CONNECT", "Failed to start metrics server at "+address).
+			WithDetails("address", address).WithCause(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(c.fixRoute(c.route), c.handler)
+	if c.federate {
+		mux.Handle(c.fixRoute(c.federateRoute), c.handler.FederateHandler())
+	}
+
+	// The actual port is registered, so port 0 can be used to listen on any free port
+	registered := cconnect.NewConnectionParams(connection.Value())
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		registered.SetPort(addr.Port)
+	}
+	err = c.registration.Register(correlationId, registered, c.handler.source, c.handler.instance, c.route)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			c.logger.Error(correlationId, err, "Metrics server at %s failed", address)
+		}
+	}()
+
+	c.server = server
+	c.listener = listener
+	c.address = listener.Addr().String()
+	c.logger.Debug(correlationId, "Opened metrics server at %s", c.address)
+	return nil
+}
+
+// Close method are closes component and frees used resources.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusMetricsServer) Close(correlationId string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.server == nil {
+		return nil
+	}
+
+	err := c.registration.Deregister(correlationId)
+	if err != nil {
+		c.logger.Error(correlationId, err, "Failed to deregister metrics endpoint")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.shutdownTimeout)*time.Millisecond)
+	defer cancel()
+
+	err = c.server.Shutdown(ctx)
+	// Shutdown misses the listener when Serve hasn't started yet, so the port is released explicitly
+	c.listener.Close()
+	c.server = nil
+	c.listener = nil
+	c.address = ""
+	if err != nil {
+		return cerr.NewInvocationError(correlationId, "CLOSE_FAILED", "Failed to close metrics server").WithCause(err)
+	}
+
+	c.logger.Debug(correlationId, "Closed metrics server")
+	return nil
+}
+
+func (c *PrometheusMetricsServer) fixRoute(route string) string {
+	if !strings.HasPrefix(route, "/") {
+		route = "/" + route
+	}
+	return route
+}
@@ -1,13 +1,16 @@
 package services
 
 import (
-	"io"
-	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
 
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
 	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
-	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
-	cinfo "github.com/pip-services3-go/pip-services3-components-go/info"
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
 	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	rpcconnect "github.com/pip-services3-go/pip-services3-rpc-go/connect"
 	rpcservices "github.com/pip-services3-go/pip-services3-rpc-go/services"
 )
 
@@ -16,9 +19,29 @@ PrometheusMetricsService is service that exposes "/metrics" route for Prometheus
 
 Configuration parameters:
 
+  - route:                   route to expose metrics (default: metrics)
+  - federate_route:          route to expose selected metrics for federation (default: federate)
   - dependencies:
     - endpoint:              override for HTTP Endpoint dependency
     - prometheus-counters:   override for PrometheusCounters dependency
+  - options:
+    - compression:           compress responses with gzip or deflate when accepted by the scraper (default: true)
+    - openmetrics:           serve OpenMetrics format with exemplars when the scraper accepts it (default: false)
+    - cache_ttl:             time in milliseconds to reuse an encoded payload, 0 to disable caching (default: 0)
+    - allowed_networks:      (optional) comma-separated list of client IP addresses or CIDR networks allowed to scrape metrics
+    - runtime_metrics:       expose Go runtime metrics with built-in GoRuntimeCollector (default: false)
+    - process_metrics:       expose process metrics with built-in ProcessCollector (default: false)
+    - federate:              expose federation route that returns series selected by match[] parameters (default: false)
+  - registration:
+    - discovery_key:         (optional) a key to register the metrics endpoint in IDiscovery services
+    - host:                  (optional) host name to register instead of the host from connection
+    - port:                  (optional) port to register instead of the port from connection
+    - labels:                (optional) additional labels to register with the endpoint
+  - credential:              (optional) credentials required to scrape metrics
+    - store_key:             (optional) a key to retrieve the credentials from ICredentialStore
+    - username:              user name for basic authentication
+    - password:              user password for basic authentication
+    - access_key:            bearer token
   - connection(s):
     - discovery_key:         (optional) a key to retrieve the connection from IDiscovery
     - protocol:              connection protocol: http or https
@@ -31,8 +54,37 @@ References:
 - *:logger:*:*:1.0         (optional)  ILogger components to pass log messages
 - *:counters:*:*:1.0         (optional)  ICounters components to pass collected measurements
 - *:discovery:*:*:1.0        (optional)  IDiscovery services to resolve connection
+- *:credential-store:*:*:1.0  (optional)  Credential stores to resolve credentials
 - *:endpoint:http:*:1.0          (optional)  HttpEndpoint reference to expose REST operation
-- *:counters:prometheus:*:1.0    PrometheusCounters reference to retrieve collected metrics
+- *:counters:prometheus:*:1.0    (optional)  PrometheusCounters reference to retrieve collected metrics
+- *:metrics-collector:*:*:1.0    (optional)  ICollector components invoked on every scrape to compute metrics
+- *:metrics-relay:*:*:1.0        (optional)  ICollector components that relay metrics of other processes
+
+The service exposes counters from all referenced CachedCounters and components that embed them,
+like PrometheusCounters and LogCounters. When there are several such sources, their metrics get
+"counters" label with the descriptor kind, for instance counters="prometheus" or counters="cached:backup".
+
+When federation is enabled, "/federate" route returns only series that match any of match[] selectors,
+for instance /federate?match[]=http_requests_total{code=~"5.."}&match[]={__name__=~"job_.*"}.
+Access control and compression are applied to it the same way as to the metrics route.
+
+When registration.discovery_key is set, the service registers its metrics endpoint in all referenced
+IDiscovery services on open. The registered connection has uri of the metrics route and labels.source,
+labels.instance, labels.scheme and labels.path parameters. Wildcard hosts like 0.0.0.0 are replaced
+by the host name. With a shared endpoint its host and port are registered, so the endpoint shall be opened before the service.
+On close the endpoint is deregistered from discovery services that implement IDeregisterableDiscovery.
+Stock discovery services like MemoryDiscovery can't remove connections, so the endpoint stays registered
+in them until they are restarted and a warning is logged.
+
+Scrapes are served by PrometheusMetricsHandler, which can also be mounted in other routers.
+Concurrent scrapes are coalesced into one collection and encoded payloads can be cached for cache_ttl.
+
+When a scrape request has X-Prometheus-Scrape-Timeout-Seconds header, collecting metrics
+is limited by that time and the service responds with 503 status when it's exceeded.
+
+When credentials or allowed networks are configured, scrapes without valid credentials are rejected
+with 401 status and scrapes from other addresses with 403 status. Rejected scrapes are counted
+in prometheus_metrics_scrape_rejected_total metric.
 
 See RestService
 See RestClient
@@ -54,9 +106,13 @@ See RestClient
 */
 type PrometheusMetricsService struct {
 	rpcservices.RestService
-	cachedCounters *ccount.CachedCounters
-	source         string
-	instance       string
+	counters           *pcount.PrometheusCounters
+	handler            *PrometheusMetricsHandler
+	route              string
+	federate           bool
+	federateRoute      string
+	connectionResolver *rpcconnect.HttpConnectionResolver
+	registration       *metricsDiscoveryRegistration
 }
 
 // NewPrometheusMetricsService are creates a new instance of c service.
@@ -65,57 +121,143 @@ type PrometheusMetricsService struct {
 func NewPrometheusMetricsService() *PrometheusMetricsService {
 	c := &PrometheusMetricsService{}
 	c.RestService = *rpcservices.InheritRestService(c)
-	c.DependencyResolver.Put("cached-counters", cref.NewDescriptor("pip-services", "counters", "cached", "*", "1.0"))
 	c.DependencyResolver.Put("prometheus-counters", cref.NewDescriptor("pip-services", "counters", "prometheus", "*", "1.0"))
+	c.route = "metrics"
+	c.federateRoute = "federate"
+	c.handler = NewPrometheusMetricsHandler()
+	c.connectionResolver = rpcconnect.NewHttpConnectionResolver()
+	c.registration = newMetricsDiscoveryRegistration(c.Logger)
 	return c
 }
 
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+//   - config *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *PrometheusMetricsService) Configure(config *cconf.ConfigParams) {
+	c.RestService.Configure(config)
+
+	c.route = config.GetAsStringWithDefault("route", c.route)
+	c.federateRoute = config.GetAsStringWithDefault("federate_route", c.federateRoute)
+	c.federate = config.GetAsBooleanWithDefault("options.federate", c.federate)
+	c.handler.Configure(config)
+	c.connectionResolver.Configure(config)
+	c.registration.Configure(config)
+}
+
 // SetReferences is sets references to dependent components.
 // Parameters:
 //   - references cref.IReferences
 // references to locate the component dependencies.
 func (c *PrometheusMetricsService) SetReferences(references cref.IReferences) {
 	c.RestService.SetReferences(references)
+	c.handler.SetReferences(references)
+	c.connectionResolver.SetReferences(references)
+	c.registration.SetReferences(references)
 
+	// Counters set by dependencies.prometheus-counters may be registered under another descriptor
 	resolv := c.DependencyResolver.GetOneOptional("prometheus-counters")
-	c.cachedCounters = resolv.(*pcount.PrometheusCounters).CachedCounters
-	if c.cachedCounters == nil {
-		resolv = c.DependencyResolver.GetOneOptional("cached-counters")
-		c.cachedCounters = resolv.(*ccount.CachedCounters)
+	c.counters, _ = resolv.(*pcount.PrometheusCounters)
+	extra := make([]pcount.ICountersReader, 0)
+	if c.counters != nil {
+		extra = append(extra, c.counters)
 	}
-	ref := references.GetOneOptional(
-		cref.NewDescriptor("pip-services", "context-info", "default", "*", "1.0"))
-	contextInfo := ref.(*cinfo.ContextInfo)
+	c.handler.sources = c.handler.resolveCountersSources(references, extra...)
+}
 
-	if contextInfo != nil && c.source == "" {
-		c.source = contextInfo.Name
+// Handler method returns the handler that serves scrapes of this service.
+// Returns *PrometheusMetricsHandler
+func (c *PrometheusMetricsService) Handler() *PrometheusMetricsHandler {
+	return c.handler
+}
+
+// Open method are opens the service.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusMetricsService) Open(correlationId string) error {
+	if c.IsOpen() {
+		return nil
 	}
-	if contextInfo != nil && c.instance == "" {
-		c.instance = contextInfo.ContextId
+
+	err := c.handler.Open(correlationId)
+	if err != nil {
+		return err
 	}
-}
 
-// Register method are registers all service routes in HTTP endpoint.
-func (c *PrometheusMetricsService) Register() {
-	c.RegisterRoute("get", "metrics", nil, func(res http.ResponseWriter, req *http.Request) { c.metrics(res, req) })
+	err = c.RestService.Open(correlationId)
+	if err != nil {
+		return err
+	}
+
+	// The connection is missing when the service uses a shared endpoint configured elsewhere
+	connection, _, _ := c.connectionResolver.Resolve(correlationId)
+	if connection == nil {
+		connection = c.endpointConnection()
+	}
+
+	path := c.route
+	if c.BaseRoute != "" {
+		path = strings.TrimSuffix(c.fixRoute(c.BaseRoute), "/") + c.fixRoute(c.route)
+	}
+	err = c.registration.Register(correlationId, connection, c.handler.source, c.handler.instance, path)
+	if err != nil {
+		c.RestService.Close(correlationId)
+		return err
+	}
+	return nil
 }
 
-// Handles metrics requests
-//   - req   an HTTP request
-//   - res   an HTTP response
-func (c *PrometheusMetricsService) metrics(res http.ResponseWriter, req *http.Request) {
+// Close method are closes the service and deregisters its endpoint from discovery services.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusMetricsService) Close(correlationId string) error {
+	err := c.registration.Deregister(correlationId)
+	if err != nil {
+		c.Logger.Error(correlationId, err, "Failed to deregister metrics endpoint")
+	}
+	return c.RestService.Close(correlationId)
+}
 
-	var counters []*ccount.Counter
-	if c.cachedCounters != nil {
-		counters = c.cachedCounters.GetAll()
+// Returns connection of the shared endpoint or nil if it's not opened yet.
+// HttpEndpoint doesn't expose its connection, so the uri it resolved on open is read by reflection.
+func (c *PrometheusMetricsService) endpointConnection() *cconnect.ConnectionParams {
+	if c.Endpoint == nil {
+		return nil
 	}
+	field := reflect.ValueOf(c.Endpoint).Elem().FieldByName("uri")
+	if !field.IsValid() || field.Kind() != reflect.String {
+		return nil
+	}
+	address, err := url.Parse(field.String())
+	if err != nil || address.Port() == "" {
+		return nil
+	}
+
+	port, _ := strconv.Atoi(address.Port())
+	connection := cconnect.NewEmptyConnectionParams()
+	connection.SetProtocol(address.Scheme)
+	connection.SetHost(address.Hostname())
+	connection.SetPort(port)
+	return connection
+}
 
-	body := pcount.PrometheusCounterConverter.ToString(counters, c.source, c.instance)
+func (c *PrometheusMetricsService) fixRoute(route string) string {
+	if !strings.HasPrefix(route, "/") {
+		route = "/" + route
+	}
+	return route
+}
 
-	res.Header().Add("content-type", "text/plain")
-	res.WriteHeader(200)
-	_, wrErr := io.WriteString(res, (string)(body))
-	if wrErr != nil {
-		c.Logger.Error("PrometheusMetricsService", wrErr, "Can't write response")
+// Register method are registers all service routes in HTTP endpoint.
+func (c *PrometheusMetricsService) Register() {
+	c.RegisterRoute("get", c.route, nil, c.handler.ServeHTTP)
+	if c.federate {
+		c.RegisterRoute("get", c.federateRoute, nil, c.handler.ServeFederate)
 	}
 }
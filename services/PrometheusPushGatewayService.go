@@ -0,0 +1,416 @@
+package services
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	rpcservices "github.com/pip-services3-go/pip-services3-rpc-go/services"
+)
+
+/*
+PrometheusPushGatewayService is a service that implements Prometheus Pushgateway HTTP API,
+so batch jobs and edge devices can push metrics into the container instead of a separate gateway:
+
+  - PUT /metrics/job/{job}{/label/value}       replaces all metrics of the group
+  - POST /metrics/job/{job}{/label/value}      replaces metrics with the same names in the group
+  - DELETE /metrics/job/{job}{/label/value}    deletes the group
+  - GET /api/v1/metrics                        returns all groups in JSON like Pushgateway
+
+Grouping labels follow the job in the path, label names with @base64 suffix have values encoded in base64url.
+Metrics are accepted in text exposition or OpenMetrics format, optionally compressed with gzip,
+and get grouping labels. Metrics with timestamps or with labels that differ from grouping labels are rejected.
+
+The service is a collector that relays pushed metrics: referenced by *:metrics-relay:*:*:1.0 descriptor,
+PrometheusMetricsService exposes metrics of all groups with push_time_seconds and push_failure_time_seconds
+of every group, while PrometheusCounters don't push them upstream again. Groups are kept in memory
+and can be persisted into a file to survive restarts.
+
+Configuration parameters:
+
+  - base_route:              (optional) base route for the Pushgateway API
+  - options:
+    - max_body_size:         maximum size in bytes of a pushed body before and after decompression, larger pushes are rejected with 413 status (default: 10 MB)
+  - persistence:
+    - path:                  (optional) path of the file to persist pushed metrics, empty to keep them only in memory
+    - interval:              interval in milliseconds to write changed metrics into the file (default: 5 min)
+  - dependencies:
+    - endpoint:              override for HTTP Endpoint dependency
+  - connection(s):
+    - discovery_key:         (optional) a key to retrieve the connection from IDiscovery
+    - protocol:              connection protocol: http or https
+    - host:                  host name or IP address
+    - port:                  port number
+    - uri:                   resource URI or connection string with all parameters in it
+
+References:
+
+- *:logger:*:*:1.0               (optional)  ILogger components to pass log messages
+- *:counters:*:*:1.0             (optional)  ICounters components to pass collected measurements
+- *:discovery:*:*:1.0            (optional)  IDiscovery services to resolve connection
+- *:endpoint:http:*:1.0          (optional)  HttpEndpoint reference to expose REST operation
+
+Example:
+
+    gateway := NewPrometheusPushGatewayService()
+    gateway.Configure(cconf.NewConfigParamsFromTuples(
+        "connection.protocol", "http",
+        "connection.host", "localhost",
+        "connection.port", 9091,
+        "persistence.path", "/var/lib/pushgateway/metrics.json",
+    ))
+
+    service := NewPrometheusMetricsService()
+    service.SetReferences(cref.NewReferencesFromTuples(
+        cref.NewDescriptor("pip-services", "metrics-relay", "pushgateway", "default", "1.0"), gateway,
+    ))
+
+    err := gateway.Open("123")
+    if err == nil {
+        fmt.Println("Metrics can be pushed to http://localhost:9091/metrics/job/myjob")
+        defer gateway.Close("")
+    }
+*/
+type PrometheusPushGatewayService struct {
+	rpcservices.RestService
+	store       *metricsPushStore
+	maxBodySize int64
+}
+
+// Counts bytes read from a request body to tell when its size limit is exceeded
+type pushBodyReader struct {
+	reader io.Reader
+	size   int64
+}
+
+func (c *pushBodyReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.size += int64(n)
+	return n, err
+}
+
+// NewPrometheusPushGatewayService creates a new instance of the service.
+// Returns *PrometheusPushGatewayService
+// pointer on new instance
+func NewPrometheusPushGatewayService() *PrometheusPushGatewayService {
+	c := &PrometheusPushGatewayService{
+		maxBodySize: 10 * 1024 * 1024,
+	}
+	c.RestService = *rpcservices.InheritRestService(c)
+	c.store = newMetricsPushStore(c.Logger)
+	return c
+}
+
+// Configure method are configures component by passing configuration parameters.
+// Parameters:
+//   - config *cconf.ConfigParams
+// configuration parameters to be set.
+func (c *PrometheusPushGatewayService) Configure(config *cconf.ConfigParams) {
+	c.RestService.Configure(config)
+	c.store.Configure(config)
+	c.maxBodySize = config.GetAsLongWithDefault("options.max_body_size", c.maxBodySize)
+}
+
+// Open method are opens the component: loads persisted metrics and starts the service.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusPushGatewayService) Open(correlationId string) error {
+	if c.IsOpen() {
+		return nil
+	}
+
+	err := c.store.Open(correlationId)
+	if err != nil {
+		return err
+	}
+
+	err = c.RestService.Open(correlationId)
+	if err != nil {
+		c.store.Close(correlationId)
+		return err
+	}
+	return nil
+}
+
+// Close method are closes component and persists pushed metrics.
+// Parameters:
+//   - correlationId string
+//	(optional) transaction id to trace execution through call chain.
+// Returns error
+// error or nil, if no errors occured.
+func (c *PrometheusPushGatewayService) Close(correlationId string) error {
+	err := c.RestService.Close(correlationId)
+	storeErr := c.store.Close(correlationId)
+	if err == nil {
+		err = storeErr
+	}
+	return err
+}
+
+// Collect method sends metrics of all pushed groups to the channel.
+//   - ch    a channel to send collected metric families.
+func (c *PrometheusPushGatewayService) Collect(ch chan<- *pcount.PrometheusMetricFamily) {
+	c.store.Collect(ch)
+}
+
+// Register method are registers all service routes in HTTP endpoint.
+func (c *PrometheusPushGatewayService) Register() {
+	c.RegisterRoute("put", "metrics/{grouping:.+}", nil, func(res http.ResponseWriter, req *http.Request) {
+		c.pushHandler(res, req, true)
+	})
+	c.RegisterRoute("post", "metrics/{grouping:.+}", nil, func(res http.ResponseWriter, req *http.Request) {
+		c.pushHandler(res, req, false)
+	})
+	c.RegisterRoute("delete", "metrics/{grouping:.+}", nil, c.deleteHandler)
+	c.RegisterRoute("get", "api/v1/metrics", nil, c.metricsHandler)
+}
+
+func (c *PrometheusPushGatewayService) pushHandler(res http.ResponseWriter, req *http.Request, replace bool) {
+	correlationId := c.GetCorrelationId(req)
+
+	labels, err := c.parseGrouping(correlationId, c.GetParam(req, "grouping"))
+	if err != nil {
+		c.SendError(res, req, err)
+		return
+	}
+
+	families, err := c.readMetrics(correlationId, res, req)
+	if err == nil {
+		err = c.store.Put(correlationId, labels, families, replace)
+	} else {
+		c.store.RecordFailure(labels)
+	}
+	if err != nil {
+		c.Logger.Warn(correlationId, "Rejected metrics pushed to %s: %s", req.URL.Path, err.Error())
+		c.SendError(res, req, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+func (c *PrometheusPushGatewayService) deleteHandler(res http.ResponseWriter, req *http.Request) {
+	correlationId := c.GetCorrelationId(req)
+
+	labels, err := c.parseGrouping(correlationId, c.GetParam(req, "grouping"))
+	if err != nil {
+		c.SendError(res, req, err)
+		return
+	}
+
+	c.store.Delete(labels)
+	res.WriteHeader(http.StatusAccepted)
+}
+
+func (c *PrometheusPushGatewayService) metricsHandler(res http.ResponseWriter, req *http.Request) {
+	groups := c.store.Groups()
+
+	data := make([]map[string]interface{}, 0, len(groups))
+	for _, group := range groups {
+		item := map[string]interface{}{
+			"labels":               group.labels,
+			"last_push_successful": group.lastPushSuccessful,
+		}
+
+		timestamp := group.pushTime.UTC().Format(time.RFC3339Nano)
+		for _, name := range c.store.familyNames(group) {
+			item[name] = c.familyToJson(group.families[name], timestamp)
+		}
+
+		pushTime := pcount.NewPrometheusMetricFamily(pushTimeMetric, pcount.PrometheusGauge,
+			"Last Unix time when changing this group in the Pushgateway succeeded.")
+		pushTime.AddSample(pushTimeMetric, group.labels, c.store.seconds(group.pushTime))
+		item[pushTimeMetric] = c.familyToJson(pushTime, timestamp)
+
+		failureTime := pcount.NewPrometheusMetricFamily(pushFailureTimeMetric, pcount.PrometheusGauge,
+			"Last Unix time when changing this group in the Pushgateway failed.")
+		failureTime.AddSample(pushFailureTimeMetric, group.labels, c.store.seconds(group.failureTime))
+		item[pushFailureTimeMetric] = c.familyToJson(failureTime, timestamp)
+
+		data = append(data, item)
+	}
+
+	c.SendResult(res, req, map[string]interface{}{"status": "success", "data": data}, nil)
+}
+
+// Converts a family into JSON like in Pushgateway API: histograms and summaries are grouped
+// into metrics with buckets or quantiles, count and sum
+func (c *PrometheusPushGatewayService) familyToJson(family *pcount.PrometheusMetricFamily, timestamp string) map[string]interface{} {
+	typ := family.Type
+	if typ == "" {
+		typ = pcount.PrometheusUntyped
+	}
+
+	metrics := make([]map[string]interface{}, 0, len(family.Samples))
+	complex := typ == pcount.PrometheusHistogram || typ == pcount.PrometheusSummary
+	byLabels := make(map[string]map[string]interface{})
+
+	for _, sample := range family.Samples {
+		value := pcount.FormatSampleValue(sample.Value)
+		if !complex {
+			metrics = append(metrics, map[string]interface{}{"labels": sample.Labels, "value": value})
+			continue
+		}
+
+		labels := make(map[string]string, len(sample.Labels))
+		for name, label := range sample.Labels {
+			if name != "le" && name != "quantile" {
+				labels[name] = label
+			}
+		}
+		key := (&pcount.PrometheusSample{Labels: labels}).Key()
+		metric, ok := byLabels[key]
+		if !ok {
+			metric = map[string]interface{}{"labels": labels}
+			if typ == pcount.PrometheusHistogram {
+				metric["buckets"] = make(map[string]string)
+			} else {
+				metric["quantiles"] = make(map[string]string)
+			}
+			byLabels[key] = metric
+			metrics = append(metrics, metric)
+		}
+
+		switch sample.Name {
+		case family.Name + "_count":
+			metric["count"] = value
+		case family.Name + "_sum":
+			metric["sum"] = value
+		case family.Name + "_bucket":
+			metric["buckets"].(map[string]string)[sample.Labels["le"]] = value
+		case family.Name:
+			if quantiles, ok := metric["quantiles"].(map[string]string); ok {
+				quantiles[sample.Labels["quantile"]] = value
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"time_stamp": timestamp,
+		"type":       strings.ToUpper(typ),
+		"help":       family.Help,
+		"metrics":    metrics,
+	}
+}
+
+// Parses grouping labels from path like job/myjob/instance/host1 or job@base64/bXlqb2I
+func (c *PrometheusPushGatewayService) parseGrouping(correlationId string, grouping string) (map[string]string, error) {
+	parts := strings.Split(grouping, "/")
+	if len(parts)%2 != 0 {
+		return nil, cerr.NewBadRequestError(correlationId, "INVALID_GROUPING",
+			"Grouping labels must be pairs of names and values").WithDetails("grouping", grouping)
+	}
+
+	labels := make(map[string]string, len(parts)/2)
+	for index := 0; index < len(parts); index += 2 {
+		name := parts[index]
+		value := parts[index+1]
+
+		if strings.HasSuffix(name, "@base64") {
+			name = strings.TrimSuffix(name, "@base64")
+			// Pushgateway accepts values with and without padding
+			decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return nil, cerr.NewBadRequestError(correlationId, "INVALID_GROUPING",
+					"Invalid base64 value of label "+name).WithDetails("grouping", grouping).WithCause(err)
+			}
+			value = string(decoded)
+		}
+
+		if !c.isLabelName(name) {
+			return nil, cerr.NewBadRequestError(correlationId, "INVALID_GROUPING",
+				"Invalid label name "+name).WithDetails("grouping", grouping)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, cerr.NewBadRequestError(correlationId, "INVALID_GROUPING",
+				"Duplicate label "+name).WithDetails("grouping", grouping)
+		}
+		labels[name] = value
+	}
+
+	if parts[0] != "job" && parts[0] != "job@base64" {
+		return nil, cerr.NewBadRequestError(correlationId, "INVALID_GROUPING",
+			"Grouping labels must start with job").WithDetails("grouping", grouping)
+	}
+	if labels["job"] == "" {
+		return nil, cerr.NewBadRequestError(correlationId, "INVALID_GROUPING",
+			"Job name must not be empty").WithDetails("grouping", grouping)
+	}
+	return labels, nil
+}
+
+func (c *PrometheusPushGatewayService) isLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for index, ch := range name {
+		isLetter := ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+		isDigit := ch >= '0' && ch <= '9'
+		if !isLetter && !(isDigit && index > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reads pushed metrics in text or OpenMetrics format, decompressing gzip bodies.
+// Bodies larger than options.max_body_size before or after decompression are rejected
+func (c *PrometheusPushGatewayService) readMetrics(correlationId string, res http.ResponseWriter,
+	req *http.Request) ([]*pcount.PrometheusMetricFamily, error) {
+	contentType := req.Header.Get("Content-Type")
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if mediaType != "" && mediaType != "text/plain" && mediaType != "application/openmetrics-text" {
+		return nil, cerr.NewBadRequestError(correlationId, "UNSUPPORTED_CONTENT_TYPE",
+			"Metrics must be pushed in text exposition or OpenMetrics format").WithDetails("content_type", contentType)
+	}
+
+	// MaxBytesReader reads at most one byte over the limit, so the counter tells when it is exceeded
+	body := &pushBodyReader{reader: req.Body}
+	var reader io.Reader = http.MaxBytesReader(res, ioutil.NopCloser(body), c.maxBodySize)
+	switch strings.ToLower(req.Header.Get("Content-Encoding")) {
+	case "", "identity":
+	case "gzip":
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			if body.size > c.maxBodySize {
+				return nil, c.bodyTooLargeError(correlationId)
+			}
+			return nil, cerr.NewBadRequestError(correlationId, "INVALID_PUSH",
+				"Failed to decompress pushed metrics").WithCause(err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	default:
+		return nil, cerr.NewBadRequestError(correlationId, "UNSUPPORTED_CONTENT_ENCODING",
+			"Pushed metrics can be compressed only with gzip").
+			WithDetails("content_encoding", req.Header.Get("Content-Encoding"))
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(reader, c.maxBodySize+1))
+	if body.size > c.maxBodySize || int64(len(data)) > c.maxBodySize {
+		return nil, c.bodyTooLargeError(correlationId)
+	}
+	if err != nil {
+		return nil, cerr.NewBadRequestError(correlationId, "INVALID_PUSH",
+			"Failed to read pushed metrics").WithCause(err)
+	}
+
+	return pcount.PrometheusCounterParser.Parse(correlationId, contentType, string(data))
+}
+
+func (c *PrometheusPushGatewayService) bodyTooLargeError(correlationId string) error {
+	return cerr.NewBadRequestError(correlationId, "PUSH_TOO_LARGE",
+		"Pushed metrics exceed maximum body size").
+		WithDetails("max_body_size", c.maxBodySize).
+		WithStatus(http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,101 @@
+package test_clients
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	pclients "github.com/pip-services3-go/pip-services3-prometheus-go/clients"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func newStatusServer(handler func(res http.ResponseWriter, req *http.Request)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(handler))
+}
+
+func get(t *testing.T, client *http.Client, url string) {
+	res, err := client.Get(url)
+	assert.Nil(t, err)
+	if res != nil {
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+}
+
+func registryText(counters *pcount.PrometheusCounters) string {
+	return pcount.PrometheusCounterConverter.FamiliesToString(counters.Registry().Families())
+}
+
+func TestInstrumentedRoundTripper(t *testing.T) {
+	server := newStatusServer(func(res http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/missing") {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res.Write([]byte("OK"))
+	})
+	defer server.Close()
+
+	// Use host name to make DNS lookup
+	address, _ := url.Parse(server.URL)
+	host := "localhost:" + address.Port()
+
+	counters := pcount.NewPrometheusCounters()
+	client := &http.Client{Transport: &http.Transport{}}
+	pclients.InstrumentClient(client, counters)
+	// Instrumenting twice doesn't wrap transport again
+	pclients.InstrumentClient(client, counters)
+
+	get(t, client, "http://"+host+"/items/1")
+	get(t, client, "http://"+host+"/items/2")
+	get(t, client, "http://"+host+"/items/missing")
+
+	text := registryText(counters)
+	assert.Contains(t, text, `http_client_requests_in_flight{host="`+host+`"} 0`)
+	assert.Contains(t, text, `http_client_request_duration_seconds_count{host="`+host+`",method="GET",status="2xx"} 2`)
+	assert.Contains(t, text, `http_client_request_duration_seconds_count{host="`+host+`",method="GET",status="4xx"} 1`)
+	// Connection is reused, so connect is measured only once
+	assert.Contains(t, text, `http_client_connect_duration_seconds_count{host="`+host+`"} 1`)
+	assert.Contains(t, text, `http_client_dns_duration_seconds_count{host="`+host+`"} 1`)
+	assert.NotContains(t, text, "http_client_tls_duration_seconds")
+}
+
+func TestInstrumentedRoundTripperTls(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	counters := pcount.NewPrometheusCounters()
+	client := server.Client()
+	pclients.InstrumentClient(client, counters)
+
+	get(t, client, server.URL)
+
+	text := registryText(counters)
+	assert.Contains(t, text, "# TYPE http_client_tls_duration_seconds histogram\n")
+	assert.Contains(t, text, `status="2xx"} 1`)
+}
+
+func TestInstrumentedRoundTripperError(t *testing.T) {
+	server := newStatusServer(func(res http.ResponseWriter, req *http.Request) {})
+	serverUrl := server.URL
+	server.Close()
+
+	counters := pcount.NewPrometheusCounters()
+	roundTripper := pclients.NewInstrumentedRoundTripper(counters, nil)
+	roundTripper.SetBuckets([]float64{1, 10})
+	client := &http.Client{Transport: roundTripper}
+
+	_, err := client.Get(serverUrl)
+	assert.NotNil(t, err)
+
+	text := registryText(counters)
+	assert.Contains(t, text, `method="GET",status="error"} 1`)
+	assert.Contains(t, text, `http_client_request_duration_seconds_bucket{host="`)
+	assert.Contains(t, text, `le="10",method="GET",status="error"} 1`)
+}
@@ -0,0 +1,73 @@
+package test_count
+
+import (
+	"strings"
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoRuntimeCollector(t *testing.T) {
+	collector := pcount.NewGoRuntimeCollector()
+	families := pcount.CollectMetricFamilies([]pcount.ICollector{collector})
+	body := pcount.PrometheusCounterConverter.FamiliesToString(families)
+
+	assert.Contains(t, body, "# TYPE go_goroutines gauge\n")
+	assert.Contains(t, body, "# TYPE go_threads gauge\n")
+	assert.Contains(t, body, "# TYPE go_gc_duration_seconds summary\n")
+	assert.Contains(t, body, `go_gc_duration_seconds{quantile="0.5"}`)
+	assert.Contains(t, body, "go_gc_duration_seconds_count ")
+	assert.Contains(t, body, "# TYPE go_memstats_heap_alloc_bytes gauge\n")
+	assert.Contains(t, body, "# TYPE go_memstats_stack_inuse_bytes gauge\n")
+	assert.Contains(t, body, "# TYPE go_memstats_mallocs_total counter\n")
+	assert.Contains(t, body, `go_info{version="`)
+
+	assert.Contains(t, body, "# TYPE go_gc_pauses_seconds histogram\n")
+	assert.Contains(t, body, `go_gc_pauses_seconds_bucket{le="+Inf"}`)
+	assert.Contains(t, body, "go_gc_pauses_seconds_sum ")
+	assert.Contains(t, body, "go_gc_pauses_seconds_count ")
+}
+
+func TestGoRuntimeCollectorBuckets(t *testing.T) {
+	collector := pcount.NewGoRuntimeCollector()
+	collector.Configure(cconf.NewConfigParamsFromTuples(
+		"options.max_buckets", 10,
+	))
+
+	families := pcount.CollectMetricFamilies([]pcount.ICollector{collector})
+	found := false
+	for _, family := range families {
+		if family.Type != pcount.PrometheusHistogram {
+			continue
+		}
+		found = true
+
+		buckets := 0
+		last := -1.0
+		for _, sample := range family.Samples {
+			if !strings.HasSuffix(sample.Name, "_bucket") {
+				continue
+			}
+			buckets++
+			assert.True(t, sample.Value >= last, "buckets in "+family.Name+" must be cumulative")
+			last = sample.Value
+		}
+		// Reduced buckets plus +Inf bucket
+		assert.True(t, buckets <= 11, family.Name+" has too many buckets")
+	}
+	assert.True(t, found)
+}
+
+func TestGoRuntimeCollectorWithoutHistograms(t *testing.T) {
+	collector := pcount.NewGoRuntimeCollector()
+	collector.Configure(cconf.NewConfigParamsFromTuples(
+		"options.histograms", false,
+	))
+
+	families := pcount.CollectMetricFamilies([]pcount.ICollector{collector})
+	for _, family := range families {
+		assert.NotEqual(t, pcount.PrometheusHistogram, family.Type)
+	}
+}
@@ -0,0 +1,96 @@
+package test_count
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeProcessStat = "1234 (my (test) app) S 1 1234 1234 0 -1 4194560 2000 0 0 0 " +
+	"250 150 0 0 20 0 12 0 5000 104857600 2560 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 3 0 0 0 0 0\n"
+
+const fakeProcessLimits = `Limit                     Soft Limit           Hard Limit           Units
+Max cpu time              unlimited            unlimited            seconds
+Max open files            1024                 1048576              files
+Max locked memory         65536                65536                bytes
+`
+
+// Creates a fake procfs directory with files read by ProcessCollector
+func newFakeProcfs(t *testing.T) string {
+	root, err := ioutil.TempDir("", "procfs")
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "self", "fd"), 0755))
+	for _, fd := range []string{"0", "1", "2"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "self", "fd", fd), []byte{}, 0644))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "self", "stat"), []byte(fakeProcessStat), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "self", "limits"), []byte(fakeProcessLimits), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "stat"),
+		[]byte("cpu  1 2 3 4\nbtime 1600000000\nprocesses 100\n"), 0644))
+
+	return root
+}
+
+func newFakeProcessCollector(root string) *pcount.ProcessCollector {
+	collector := pcount.NewProcessCollector()
+	collector.Configure(cconf.NewConfigParamsFromTuples(
+		"options.proc_path", root,
+	))
+	return collector
+}
+
+func TestProcessCollector(t *testing.T) {
+	root := newFakeProcfs(t)
+	defer os.RemoveAll(root)
+
+	families := pcount.CollectMetricFamilies([]pcount.ICollector{newFakeProcessCollector(root)})
+	body := pcount.PrometheusCounterConverter.FamiliesToString(families)
+
+	assert.Contains(t, body, "# TYPE process_cpu_seconds_total counter\nprocess_cpu_seconds_total 4\n")
+	assert.Contains(t, body, "process_virtual_memory_bytes 104857600\n")
+	assert.Contains(t, body, "process_resident_memory_bytes "+
+		pcount.FormatSampleValue(float64(2560*os.Getpagesize()))+"\n")
+	assert.Contains(t, body, "process_start_time_seconds 1600000050\n")
+	assert.Contains(t, body, "process_open_fds 3\n")
+	assert.Contains(t, body, "process_max_fds 1024\n")
+}
+
+func TestProcessCollectorMissingFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "procfs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	families := pcount.CollectMetricFamilies([]pcount.ICollector{newFakeProcessCollector(root)})
+	assert.Len(t, families, 0)
+}
+
+func TestPrometheusCountersPushCollectors(t *testing.T) {
+	root := newFakeProcfs(t)
+	defer os.RemoveAll(root)
+
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway)
+	defer counters.Close("")
+	counters.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "metrics-collector", "process", "default", "1.0"), newFakeProcessCollector(root),
+	))
+
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 1)
+	assert.Contains(t, requests[0].body, "test_counter1 1")
+	assert.Contains(t, requests[0].body, "process_open_fds 3\n")
+	assert.Contains(t, requests[0].body, "process_max_fds 1024\n")
+}
@@ -0,0 +1,185 @@
+package test_count
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusCounterConverterEmpty(t *testing.T) {
+	body := pcount.PrometheusCounterConverter.ToString(nil, "", "")
+	assert.Equal(t, "", body)
+
+	body = pcount.PrometheusCounterConverter.ToString([]*ccount.Counter{}, "MyApp", "MyInstance")
+	assert.Equal(t, "", body)
+}
+
+func TestPrometheusCounterConverterSimpleCounters(t *testing.T) {
+	counter1 := ccount.NewCounter("MyService.MyCommand.Calls", ccount.Increment)
+	counter1.Count = 5
+	counter2 := ccount.NewCounter("MyService.LastValue", ccount.LastValue)
+	counter2.Last = 0.5
+	counter3 := ccount.NewCounter("MyService.Timestamp", ccount.Timestamp)
+	counter3.Time = time.Unix(1600000000, 0)
+
+	body := pcount.PrometheusCounterConverter.ToString(
+		[]*ccount.Counter{counter1, counter2, counter3}, "MyApp", "MyInstance")
+
+	expected := "# TYPE myservice_lastvalue gauge\n" +
+		"myservice_lastvalue{instance=\"MyInstance\",source=\"MyApp\"} 0.5\n" +
+		"# TYPE myservice_mycommand_calls gauge\n" +
+		"myservice_mycommand_calls{instance=\"MyInstance\",source=\"MyApp\"} 5\n" +
+		"# TYPE myservice_timestamp gauge\n" +
+		"myservice_timestamp{instance=\"MyInstance\",source=\"MyApp\"} 1600000000\n"
+	assert.Equal(t, expected, body)
+}
+
+func TestPrometheusCounterConverterGroupsFamilies(t *testing.T) {
+	counter1 := ccount.NewCounter("MyService.MyCommand1.exec_time", ccount.Interval)
+	counter1.Count = 2
+	counter1.Min = 1
+	counter1.Max = 3
+	counter1.Average = 2
+	counter2 := ccount.NewCounter("MyService.MyCommand2.exec_time", ccount.Interval)
+	counter2.Count = 1
+	counter2.Min = 4
+	counter2.Max = 4
+	counter2.Average = 4
+
+	families := pcount.PrometheusCounterConverter.ToMetricFamilies(
+		[]*ccount.Counter{counter1, counter2}, "", "")
+
+	assert.Len(t, families, 4)
+	assert.Equal(t, "exec_time_average", families[0].Name)
+	assert.Equal(t, "exec_time_count", families[1].Name)
+	assert.Equal(t, "exec_time_max", families[2].Name)
+	assert.Equal(t, "exec_time_min", families[3].Name)
+	assert.Len(t, families[3].Samples, 2)
+	assert.Equal(t, "MyCommand1", families[3].Samples[0].Labels["command"])
+	assert.Equal(t, "MyService", families[3].Samples[0].Labels["service"])
+	assert.Equal(t, float64(1), families[3].Samples[0].Value)
+	assert.Equal(t, float64(4), families[3].Samples[1].Value)
+
+	body := pcount.PrometheusCounterConverter.FamiliesToString(families)
+	expected := "# TYPE exec_time_max gauge\n" +
+		"exec_time_max{command=\"MyCommand1\",service=\"MyService\"} 3\n" +
+		"exec_time_max{command=\"MyCommand2\",service=\"MyService\"} 4\n"
+	assert.Contains(t, body, expected)
+}
+
+func TestPrometheusCounterConverterFamiliesToString(t *testing.T) {
+	family := pcount.NewPrometheusMetricFamily("my_metric", pcount.PrometheusCounter, "My help\nwith \\ slash")
+	family.AddSample("my_metric", map[string]string{"label": "a\"b"}, math.Inf(1))
+	sample := family.AddSample("my_metric", nil, 1e-9)
+	sample.Timestamp = 1600000000000
+
+	body := pcount.PrometheusCounterConverter.FamiliesToString([]*pcount.PrometheusMetricFamily{family})
+
+	expected := "# HELP my_metric My help\\nwith \\\\ slash\n" +
+		"# TYPE my_metric counter\n" +
+		"my_metric{label=\"a\\\"b\"} +Inf\n" +
+		"my_metric 1e-09 1600000000000\n"
+	assert.Equal(t, expected, body)
+}
+
+type staticCollector struct {
+	families []*pcount.PrometheusMetricFamily
+}
+
+func (c *staticCollector) Collect(ch chan<- *pcount.PrometheusMetricFamily) {
+	for _, family := range c.families {
+		ch <- family
+	}
+}
+
+func TestCollectMetricFamilies(t *testing.T) {
+	family1 := pcount.NewPrometheusMetricFamily("metric_b", pcount.PrometheusGauge, "First")
+	family1.AddSample("metric_b", map[string]string{"id": "1"}, 1)
+	family2 := pcount.NewPrometheusMetricFamily("metric_b", pcount.PrometheusGauge, "Second")
+	family2.AddSample("metric_b", map[string]string{"id": "2"}, 2)
+	family3 := pcount.NewPrometheusMetricFamily("metric_a", pcount.PrometheusCounter, "")
+	family3.AddSample("metric_a", nil, 3)
+
+	families := pcount.CollectMetricFamilies([]pcount.ICollector{
+		&staticCollector{families: []*pcount.PrometheusMetricFamily{family1, family3}},
+		&staticCollector{families: []*pcount.PrometheusMetricFamily{family2}},
+	})
+
+	assert.Len(t, families, 2)
+	assert.Equal(t, "metric_a", families[0].Name)
+	assert.Equal(t, "metric_b", families[1].Name)
+	assert.Equal(t, pcount.PrometheusGauge, families[1].Type)
+	assert.Len(t, families[1].Samples, 2)
+
+	assert.Len(t, pcount.CollectMetricFamilies(nil), 0)
+}
+
+func TestPrometheusCounterConverterFamiliesToOpenMetrics(t *testing.T) {
+	counter := pcount.NewPrometheusMetricFamily("requests_total", pcount.PrometheusCounter, "Total \"requests\"")
+	sample := counter.AddSample("requests_total", map[string]string{"code": "200"}, 3)
+	sample.Exemplar = &pcount.PrometheusExemplar{
+		Labels:    map[string]string{"trace_id": "123"},
+		Value:     1,
+		Timestamp: 1600000000500,
+	}
+	gauge := pcount.NewPrometheusMetricFamily("queue_length", "", "")
+	sample = gauge.AddSample("queue_length", nil, 5)
+	sample.Timestamp = 1600000000000
+	// Exemplars are written only for counters and histogram buckets
+	sample.Exemplar = &pcount.PrometheusExemplar{Labels: map[string]string{"trace_id": "456"}, Value: 5}
+
+	body := pcount.PrometheusCounterConverter.FamiliesToOpenMetrics([]*pcount.PrometheusMetricFamily{counter, gauge})
+
+	expected := "# HELP requests Total \\\"requests\\\"\n" +
+		"# TYPE requests counter\n" +
+		"requests_total{code=\"200\"} 3 # {trace_id=\"123\"} 1 1600000000.5\n" +
+		"# TYPE queue_length unknown\n" +
+		"queue_length 5 1600000000\n" +
+		"# EOF\n"
+	assert.Equal(t, expected, body)
+}
+
+func TestPrometheusCounterConverterExemplars(t *testing.T) {
+	counter1 := ccount.NewCounter("MyService.MyCommand.exec_time", ccount.Interval)
+	counter1.Count = 2
+	counter2 := ccount.NewCounter("MyService.Calls", ccount.Increment)
+	counter2.Count = 3
+	exemplars := map[string]*pcount.PrometheusExemplar{
+		"MyService.MyCommand.exec_time": pcount.NewPrometheusExemplar("123", 15),
+		"MyService.Calls":               pcount.NewPrometheusExemplar("456", 1),
+	}
+
+	families := pcount.PrometheusCounterConverter.ToMetricFamiliesWithExemplars(
+		[]*ccount.Counter{counter1, counter2}, exemplars, "", "")
+	body := pcount.PrometheusCounterConverter.FamiliesToOpenMetrics(families)
+	assert.Contains(t, body, "# TYPE myservice_calls counter\nmyservice_calls_total 3 # {trace_id=\"456\"} 1 ")
+
+	// OpenMetrics doesn't allow exemplars on gauges
+	assert.Contains(t, body, "exec_time_count{command=\"MyCommand\",service=\"MyService\"} 2\n")
+	assert.NotContains(t, body, "trace_id=\"123\"")
+
+	// Exemplars are not written in Prometheus text format
+	body = pcount.PrometheusCounterConverter.FamiliesToString(families)
+	assert.NotContains(t, body, "trace_id")
+
+	// Counters are exposed as gauges in Prometheus text format
+	families = pcount.PrometheusCounterConverter.ToMetricFamilies([]*ccount.Counter{counter2}, "", "")
+	assert.Equal(t, pcount.PrometheusGauge, families[0].Type)
+}
+
+func TestPrometheusCounterConverterExportLabels(t *testing.T) {
+	family := pcount.NewPrometheusMetricFamily("queue_length", pcount.PrometheusGauge, "")
+	family.AddSample("queue_length", map[string]string{"instance": "sidecar1", "source": "app"}, 1)
+	family.AddSample("queue_length", nil, 2)
+
+	pcount.PrometheusCounterConverter.ExportLabels([]*pcount.PrometheusMetricFamily{family},
+		map[string]string{"source": "app", "instance": "host1", "target": ""})
+
+	assert.Equal(t, map[string]string{"instance": "host1", "exported_instance": "sidecar1", "source": "app"},
+		family.Samples[0].Labels)
+	assert.Equal(t, map[string]string{"instance": "host1", "source": "app"}, family.Samples[1].Labels)
+}
@@ -0,0 +1,184 @@
+package test_count
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusCounterParserText(t *testing.T) {
+	text := "# HELP http_requests_total Total number of \\\\ requests\\n\n" +
+		"# TYPE http_requests_total counter\n" +
+		"http_requests_total{code=\"200\",path=\"/a \\\"b\\\"\"} 3 1600000000000\n" +
+		"http_requests_total{code=\"500\",} 1\n" +
+		"\n" +
+		"# TYPE latency histogram\n" +
+		"latency_bucket{le=\"0.5\"} 1\n" +
+		"latency_bucket{le=\"+Inf\"} 2\n" +
+		"latency_sum 1.5\n" +
+		"latency_count 2\n" +
+		"# just a comment\n" +
+		"temperature -Inf\n"
+
+	families, err := pcount.PrometheusCounterParser.ParseText("", text)
+	assert.Nil(t, err)
+	if !assert.Len(t, families, 3) {
+		return
+	}
+
+	assert.Equal(t, "http_requests_total", families[0].Name)
+	assert.Equal(t, pcount.PrometheusCounter, families[0].Type)
+	assert.Equal(t, "Total number of \\ requests\n", families[0].Help)
+	if assert.Len(t, families[0].Samples, 2) {
+		sample := families[0].Samples[0]
+		assert.Equal(t, map[string]string{"code": "200", "path": "/a \"b\""}, sample.Labels)
+		assert.Equal(t, 3.0, sample.Value)
+		assert.Equal(t, int64(1600000000000), sample.Timestamp)
+	}
+
+	assert.Equal(t, "latency", families[1].Name)
+	assert.Equal(t, pcount.PrometheusHistogram, families[1].Type)
+	if assert.Len(t, families[1].Samples, 4) {
+		assert.Equal(t, "latency_count", families[1].Samples[3].Name)
+	}
+
+	assert.Equal(t, "temperature", families[2].Name)
+	assert.Equal(t, pcount.PrometheusUntyped, families[2].Type)
+	assert.True(t, math.IsInf(families[2].Samples[0].Value, -1))
+
+	// Written metrics are parsed back into the same families
+	written := pcount.PrometheusCounterConverter.FamiliesToString(families)
+	families, err = pcount.PrometheusCounterParser.ParseText("", written)
+	assert.Nil(t, err)
+	assert.Equal(t, written, pcount.PrometheusCounterConverter.FamiliesToString(families))
+}
+
+func TestPrometheusCounterParserOpenMetrics(t *testing.T) {
+	registry := pcount.NewPrometheusMetricsRegistry()
+	registry.AddCounterWithExemplar("http_requests_total", "Total requests", map[string]string{"code": "200"}, 2,
+		&pcount.PrometheusExemplar{Labels: map[string]string{"trace_id": "123"}, Value: 1, Timestamp: 1600000000500})
+	registry.SetGauge("memory", "Memory \"used\"", nil, 10)
+
+	text := pcount.PrometheusCounterConverter.FamiliesToOpenMetrics(registry.Families())
+	families, err := pcount.PrometheusCounterParser.Parse("", "application/openmetrics-text; version=1.0.0", text)
+	assert.Nil(t, err)
+
+	assert.Equal(t, text, pcount.PrometheusCounterConverter.FamiliesToOpenMetrics(families))
+	if assert.Len(t, families, 2) {
+		assert.Equal(t, "http_requests_total", families[0].Name)
+		assert.Equal(t, pcount.PrometheusCounter, families[0].Type)
+		exemplar := families[0].Samples[0].Exemplar
+		if assert.NotNil(t, exemplar) {
+			assert.Equal(t, "123", exemplar.Labels["trace_id"])
+			assert.Equal(t, int64(1600000000500), exemplar.Timestamp)
+		}
+		assert.Equal(t, "Memory \"used\"", families[1].Help)
+	}
+
+	_, err = pcount.PrometheusCounterParser.ParseOpenMetrics("", "# TYPE a gauge\na 1\n")
+	assert.NotNil(t, err)
+
+	// Exemplars are allowed only on counter totals and histogram buckets
+	_, err = pcount.PrometheusCounterParser.ParseOpenMetrics("",
+		"# TYPE a gauge\na 1 # {trace_id=\"1\"} 1\n# EOF\n")
+	assert.NotNil(t, err)
+	_, err = pcount.PrometheusCounterParser.ParseOpenMetrics("",
+		"# TYPE b histogram\nb_bucket{le=\"+Inf\"} 1 # {trace_id=\"1\"} 1\nb_count 1 # {trace_id=\"1\"} 1\n# EOF\n")
+	assert.NotNil(t, err)
+	_, err = pcount.PrometheusCounterParser.ParseOpenMetrics("",
+		"# TYPE b histogram\nb_bucket{le=\"+Inf\"} 1 # {trace_id=\"1\"} 1\nb_count 1\n# EOF\n")
+	assert.Nil(t, err)
+}
+
+func TestPrometheusCounterParserErrors(t *testing.T) {
+	invalid := []string{
+		"metric{label=\"value\" 1\n",
+		"metric{label=value} 1\n",
+		"metric NaN x\n",
+		"metric one\n",
+		"metric{a=\"1\",a=\"2\"} 1\n",
+		"# TYPE metric gauge\n# TYPE metric gauge\n",
+		"# TYPE metric unknown\n",
+		"metric 1 # {trace_id=\"1\"} 1\n",
+	}
+
+	for _, text := range invalid {
+		// Errors are reported at the last line
+		text = "# TYPE other gauge\nother 1\n" + text
+		_, err := pcount.PrometheusCounterParser.ParseText("123", text)
+		if assert.NotNil(t, err, text) {
+			appErr, ok := err.(*cerr.ApplicationError)
+			if assert.True(t, ok) {
+				assert.Equal(t, "INVALID_METRICS", appErr.Code)
+				assert.Equal(t, "123", appErr.CorrelationId)
+				assert.Contains(t, appErr.Message, "line "+strconv.Itoa(strings.Count(text, "\n")))
+			}
+		}
+	}
+}
+
+func TestPrometheusCounterParserToCounters(t *testing.T) {
+	counter1 := ccount.NewCounter("MyService.MyCommand.exec_time", ccount.Interval)
+	counter1.Count = 2
+	counter1.Min = 1
+	counter1.Max = 3
+	counter1.Average = 2
+	counter2 := ccount.NewCounter("MyService.Calls", ccount.Increment)
+	counter2.Count = 5
+	counter3 := ccount.NewCounter("MyService.Value", ccount.LastValue)
+	counter3.Last = 0.5
+
+	text := pcount.PrometheusCounterConverter.ToString(
+		[]*ccount.Counter{counter1, counter2, counter3}, "MyApp", "MyInstance")
+	families, err := pcount.PrometheusCounterParser.ParseText("", text)
+	assert.Nil(t, err)
+
+	counters := pcount.PrometheusCounterParser.ToCounters(families)
+	byName := make(map[string]*ccount.Counter)
+	for _, counter := range counters {
+		byName[counter.Name] = counter
+	}
+	assert.Len(t, counters, 3)
+
+	counter := byName["MyService.MyCommand.exec_time"]
+	if assert.NotNil(t, counter) {
+		assert.Equal(t, ccount.Statistics, counter.Type)
+		assert.Equal(t, 2, counter.Count)
+		assert.Equal(t, float32(1), counter.Min)
+		assert.Equal(t, float32(3), counter.Max)
+		assert.Equal(t, float32(2), counter.Average)
+	}
+
+	counter = byName["myservice_calls"]
+	if assert.NotNil(t, counter) {
+		assert.Equal(t, ccount.LastValue, counter.Type)
+		assert.Equal(t, float32(5), counter.Last)
+	}
+
+	families, _ = pcount.PrometheusCounterParser.ParseText("",
+		"# TYPE requests_total counter\n"+
+			"requests_total{code=\"200\"} 7 1600000000000\n"+
+			"# TYPE latency summary\n"+
+			"latency{quantile=\"0.5\"} 0.2\n"+
+			"latency_sum 3\n"+
+			"latency_count 4\n")
+	counters = pcount.PrometheusCounterParser.ToCounters(families)
+	if assert.Len(t, counters, 2) {
+		assert.Equal(t, "requests_total{code=\"200\"}", counters[0].Name)
+		assert.Equal(t, ccount.Increment, counters[0].Type)
+		assert.Equal(t, 7, counters[0].Count)
+		assert.True(t, time.Unix(1600000000, 0).Equal(counters[0].Time))
+
+		assert.Equal(t, "latency", counters[1].Name)
+		assert.Equal(t, ccount.Interval, counters[1].Type)
+		assert.Equal(t, 4, counters[1].Count)
+		assert.Equal(t, float32(0.75), counters[1].Average)
+	}
+}
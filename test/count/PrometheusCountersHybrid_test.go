@@ -0,0 +1,111 @@
+package test_count
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusCountersPushFallback(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.mode", "fallback",
+		"push.scrape_window", 100,
+	)
+	defer counters.Close("")
+
+	// No pushes within the first scrape window after opening
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+	assert.Len(t, gateway.Requests(), 0)
+
+	// No scrapes, so pushing starts
+	time.Sleep(150 * time.Millisecond)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPut, requests[0].method)
+	assert.Contains(t, requests[0].body, "test_counter1 1")
+
+	// Scrapes resumed, so pushing stops and the group is deleted
+	counters.NotifyScrape()
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests = gateway.Requests()
+	assert.Len(t, requests, 3)
+	assert.Equal(t, http.MethodDelete, requests[2].method)
+	assert.Equal(t, "/metrics/job/test/instance/host1", requests[2].path)
+}
+
+func TestPrometheusCountersPushFallbackRetriesDelete(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.mode", "fallback",
+		"push.scrape_window", 100,
+		"options.retries", 1,
+	)
+	defer counters.Close("")
+
+	time.Sleep(150 * time.Millisecond)
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	// Failed deletion is retried until the group is deleted
+	counters.NotifyScrape()
+	gateway.QueueStatuses(http.StatusInternalServerError)
+	err = counters.Save(counters.GetAll())
+	assert.NotNil(t, err)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	if assert.Len(t, requests, 3) {
+		assert.Equal(t, http.MethodPut, requests[0].method)
+		assert.Equal(t, http.MethodDelete, requests[1].method)
+		assert.Equal(t, http.MethodDelete, requests[2].method)
+	}
+}
+
+func TestPrometheusCountersPushAlwaysIgnoresScrapes(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway)
+	defer counters.Close("")
+
+	counters.NotifyScrape()
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+	assert.Len(t, gateway.Requests(), 1)
+}
+
+func TestPrometheusCountersInvalidPushMode(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"push.mode", "sometimes",
+	))
+
+	err := counters.Open("")
+	assert.NotNil(t, err)
+	assert.False(t, counters.IsOpen())
+}
@@ -0,0 +1,514 @@
+package test_count
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+type pushedRequest struct {
+	method   string
+	path     string
+	encoding string
+	body     string
+}
+
+// Fake PushGateway that records received requests
+type pushGatewayMock struct {
+	server   *httptest.Server
+	lock     sync.Mutex
+	requests []*pushedRequest
+	status   int
+	statuses []int
+}
+
+func newPushGatewayMock() *pushGatewayMock {
+	c := &pushGatewayMock{status: http.StatusOK}
+	c.server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			reader, err := gzip.NewReader(bytes.NewReader(data))
+			if err == nil {
+				data, _ = ioutil.ReadAll(reader)
+			}
+		}
+
+		c.lock.Lock()
+		c.requests = append(c.requests, &pushedRequest{
+			method:   req.Method,
+			path:     req.URL.Path,
+			encoding: req.Header.Get("Content-Encoding"),
+			body:     string(data),
+		})
+		status := c.status
+		if len(c.statuses) > 0 {
+			status = c.statuses[0]
+			c.statuses = c.statuses[1:]
+		}
+		c.lock.Unlock()
+
+		res.WriteHeader(status)
+	}))
+	return c
+}
+
+func (c *pushGatewayMock) Requests() []*pushedRequest {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	result := make([]*pushedRequest, len(c.requests))
+	copy(result, c.requests)
+	return result
+}
+
+func (c *pushGatewayMock) SetStatus(status int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.status = status
+}
+
+// Sets statuses returned for the next requests one by one
+func (c *pushGatewayMock) QueueStatuses(statuses ...int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.statuses = append(c.statuses, statuses...)
+}
+
+func (c *pushGatewayMock) Close() {
+	c.server.Close()
+}
+
+func newPushCounters(t *testing.T, gateway *pushGatewayMock, tuples ...interface{}) *pcount.PrometheusCounters {
+	address, _ := url.Parse(gateway.server.URL)
+	port, _ := strconv.Atoi(address.Port())
+
+	config := cconf.NewConfigParamsFromTuples(
+		"source", "test",
+		"instance", "host1",
+		"connection.protocol", "http",
+		"connection.host", address.Hostname(),
+		"connection.port", port,
+		"options.retries", 1,
+	)
+	config = cconf.NewConfigParamsFromTuples(tuples...).SetDefaults(config)
+
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(config)
+	err := counters.Open("")
+	assert.Nil(t, err)
+	return counters
+}
+
+func TestPrometheusCountersPushUncompressed(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, http.MethodPut, requests[0].method)
+	assert.Equal(t, "/metrics/job/test/instance/host1", requests[0].path)
+	assert.Equal(t, "", requests[0].encoding)
+	assert.Contains(t, requests[0].body, "test_counter1 1")
+}
+
+func TestPrometheusCountersPushGzip(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.compression", "gzip",
+		"push.compression_min_size", 100,
+	)
+	defer counters.Close("")
+
+	// Small body stays uncompressed
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	// Large body is compressed
+	for i := 0; i < 20; i++ {
+		counters.IncrementOne("test.counter" + strconv.Itoa(i))
+	}
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 2)
+	assert.Equal(t, "", requests[0].encoding)
+	assert.Equal(t, "gzip", requests[1].encoding)
+	assert.True(t, strings.Contains(requests[1].body, "test_counter19 1"))
+}
+
+func TestPrometheusCountersUnsupportedCompression(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"push.compression", "lz4",
+	))
+
+	err := counters.Open("")
+	assert.NotNil(t, err)
+}
+
+func TestPrometheusCountersPushFailure(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+	gateway.SetStatus(http.StatusBadRequest)
+
+	counters := newPushCounters(t, gateway)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.NotNil(t, err)
+}
+
+func TestPrometheusCountersPushDelta(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.delta", true,
+	)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	counters.Last("test.counter2", 2)
+
+	// The first push is always a full one
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	// Nothing changed, nothing is sent
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	// Only the changed family is sent
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPut, requests[0].method)
+	assert.Contains(t, requests[0].body, "test_counter1 1")
+	assert.Contains(t, requests[0].body, "test_counter2 2")
+	assert.Equal(t, http.MethodPost, requests[1].method)
+	assert.Contains(t, requests[1].body, "test_counter1 2")
+	assert.NotContains(t, requests[1].body, "test_counter2")
+}
+
+func TestPrometheusCountersPushDeltaSendsWholeFamily(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.delta", true,
+	)
+	defer counters.Close("")
+
+	counters.Last("service1.command1.exec_time", 1)
+	counters.Last("service1.command2.exec_time", 2)
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	counters.Last("service1.command1.exec_time", 3)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	// POST replaces all series with the same name, so unchanged series of the family are sent too
+	requests := gateway.Requests()
+	assert.Len(t, requests, 2)
+	assert.Contains(t, requests[1].body, `command="command1"`)
+	assert.Contains(t, requests[1].body, `command="command2"`)
+}
+
+func TestPrometheusCountersPushDeltaRemovedFamily(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.delta", true,
+	)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	counters.Last("test.counter2", 2)
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	// POST can't delete a series, so the group is replaced when a family disappears
+	err = counters.Save([]*ccount.Counter{counters.Get("test.counter1", ccount.Increment)})
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPut, requests[1].method)
+	assert.Contains(t, requests[1].body, "test_counter1 1")
+	assert.NotContains(t, requests[1].body, "test_counter2")
+}
+
+func TestPrometheusCountersPushDeltaResync(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.delta", true,
+		"push.resync_interval", 1,
+	)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 2)
+	assert.Equal(t, http.MethodPut, requests[1].method)
+	assert.Contains(t, requests[1].body, "test_counter1 1")
+}
+
+func TestPrometheusCountersPushDeltaRetriesFailedFamilies(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.delta", true,
+	)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	gateway.SetStatus(http.StatusInternalServerError)
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.NotNil(t, err)
+
+	gateway.SetStatus(http.StatusOK)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 3)
+	assert.Equal(t, http.MethodPost, requests[2].method)
+	assert.Contains(t, requests[2].body, "test_counter1 2")
+}
+
+func TestPrometheusCountersPushPost(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.method", "post",
+	)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, http.MethodPost, requests[0].method)
+}
+
+func TestPrometheusCountersPushBatches(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.max_body_size", 200,
+	)
+	defer counters.Close("")
+
+	counters.Last("service1.command1.exec_time", 1)
+	counters.Last("service1.command2.exec_time", 2)
+	counters.Last("service1.command3.exec_time", 3)
+	for i := 0; i < 10; i++ {
+		counters.IncrementOne("test.counter" + strconv.Itoa(i))
+	}
+
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	assert.True(t, len(requests) > 1)
+	assert.Equal(t, http.MethodPut, requests[0].method)
+
+	bodies := ""
+	for index, request := range requests {
+		if index > 0 {
+			assert.Equal(t, http.MethodPost, request.method)
+		}
+		// A family is never split between requests
+		if strings.Contains(request.body, "exec_time") {
+			assert.Equal(t, 3, strings.Count(request.body, "exec_time{"))
+		} else if len(request.body) > 200 {
+			assert.Equal(t, 1, strings.Count(request.body, "# TYPE"))
+		}
+		bodies += request.body
+	}
+
+	assert.Equal(t, 1, strings.Count(bodies, "# TYPE exec_time gauge"))
+	for i := 0; i < 10; i++ {
+		assert.Contains(t, bodies, "test_counter"+strconv.Itoa(i)+" 1")
+	}
+}
+
+func TestPrometheusCountersPushBatchFailures(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.max_body_size", 1,
+	)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	counters.IncrementOne("test.counter2")
+	counters.IncrementOne("test.counter3")
+
+	gateway.QueueStatuses(http.StatusOK, http.StatusInternalServerError, http.StatusOK)
+	err := counters.Save(counters.GetAll())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "1 of 3")
+
+	// The failed batch doesn't prevent others from being pushed
+	requests := gateway.Requests()
+	assert.Len(t, requests, 3)
+}
+
+func TestPrometheusCountersPushTelemetryRetries(t *testing.T) {
+	gateway := newPushGatewayMock()
+	counters := newPushCounters(t, gateway,
+		"options.retries", 3,
+	)
+	defer counters.Close("")
+
+	// Connection is refused, so the push is retried
+	gateway.Close()
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.NotNil(t, err)
+
+	body := pcount.PrometheusCounterConverter.FamiliesToString(counters.RegistryFamilies())
+	assert.Contains(t, body, "prometheus_counters_push_attempts_total 3\n")
+	assert.Contains(t, body, "prometheus_counters_push_failures_total{reason=\"connection\"} 1\n")
+}
+
+func TestPrometheusCountersPushFailedReplace(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.max_body_size", 1,
+	)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	counters.IncrementOne("test.counter2")
+	counters.IncrementOne("test.counter3")
+
+	gateway.QueueStatuses(http.StatusInternalServerError)
+	err := counters.Save(counters.GetAll())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "3 of 3")
+
+	// Other batches are not merged into the group that failed to be replaced
+	requests := gateway.Requests()
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, http.MethodPut, requests[0].method)
+	}
+}
+
+func TestPrometheusCountersPushTelemetry(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway,
+		"push.delta", true,
+	)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	gateway.SetStatus(http.StatusServiceUnavailable)
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.NotNil(t, err)
+
+	gateway.SetStatus(http.StatusOK)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	body := pcount.PrometheusCounterConverter.FamiliesToString(counters.RegistryFamilies())
+	assert.Contains(t, body, "prometheus_counters_push_attempts_total 3\n")
+	assert.Contains(t, body, "prometheus_counters_push_failures_total{reason=\"http_5xx\"} 1\n")
+	assert.Contains(t, body, "prometheus_counters_push_duration_seconds_count 3\n")
+	assert.Contains(t, body, "prometheus_counters_push_queue_depth 0\n")
+	assert.Contains(t, body, "# TYPE prometheus_counters_push_last_success_timestamp_seconds gauge\n")
+	assert.Contains(t, body, "# TYPE prometheus_counters_push_payload_bytes_total counter\n")
+
+	// Telemetry of previous pushes goes with the next push
+	requests := gateway.Requests()
+	assert.Len(t, requests, 3)
+	assert.Contains(t, requests[2].body, "prometheus_counters_push_attempts_total 2\n")
+	assert.Contains(t, requests[2].body, "test_counter1 2\n")
+}
+
+func TestPrometheusCountersPushSkipsRelays(t *testing.T) {
+	gateway := newPushGatewayMock()
+	defer gateway.Close()
+
+	counters := newPushCounters(t, gateway)
+	defer counters.Close("")
+
+	collected := pcount.NewPrometheusMetricFamily("queue_length", pcount.PrometheusGauge, "")
+	collected.AddSample("queue_length", nil, 5)
+	relayed := pcount.NewPrometheusMetricFamily("envoy_requests_total", pcount.PrometheusCounter, "")
+	relayed.AddSample("envoy_requests_total", nil, 7)
+	counters.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "metrics-collector", "queue", "default", "1.0"),
+		&staticCollector{families: []*pcount.PrometheusMetricFamily{collected}},
+		cref.NewDescriptor("pip-services", "metrics-relay", "scrape", "default", "1.0"),
+		&staticCollector{families: []*pcount.PrometheusMetricFamily{relayed}},
+	))
+
+	err := counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	requests := gateway.Requests()
+	if assert.Len(t, requests, 1) {
+		assert.Contains(t, requests[0].body, "queue_length 5\n")
+		assert.NotContains(t, requests[0].body, "envoy_requests_total")
+	}
+}
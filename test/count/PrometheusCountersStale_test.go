@@ -0,0 +1,133 @@
+package test_count
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func counterNames(counters *pcount.PrometheusCounters) []string {
+	names := make([]string, 0)
+	for _, counter := range counters.GetAll() {
+		names = append(names, counter.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPrometheusCountersStaleMark(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"stale.ttl", 50,
+	))
+
+	counters.Increment("test.counter1", 1)
+	counters.Increment("test.counter2", 1)
+	assert.Equal(t, []string{"test.counter1", "test.counter2"}, counterNames(counters))
+
+	time.Sleep(100 * time.Millisecond)
+	counters.Increment("test.counter1", 1)
+	assert.Equal(t, []string{"test.counter1"}, counterNames(counters))
+
+	// Stale counter is kept and continues from the last value
+	counters.Increment("test.counter2", 1)
+	all := counters.GetAll()
+	assert.Len(t, all, 2)
+	for _, counter := range all {
+		assert.Equal(t, 2, counter.Count)
+	}
+}
+
+func TestPrometheusCountersStaleRemove(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"stale.ttl", 50,
+		"stale.mode", "remove",
+	))
+
+	counters.Increment("test.counter1", 1)
+	assert.Len(t, counters.GetAll(), 1)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, counters.GetAll(), 0)
+
+	// Removed counter starts from scratch
+	counters.Increment("test.counter1", 1)
+	all := counters.GetAll()
+	assert.Len(t, all, 1)
+	assert.Equal(t, 1, all[0].Count)
+}
+
+func TestPrometheusCountersStaleMetricTtl(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"stale.metrics.test.short", 50,
+		"stale.metrics.test.prefix*", 50,
+	))
+
+	counters.Increment("test.short", 1)
+	counters.Increment("test.prefix.counter", 1)
+	counters.Increment("test.forever", 1)
+	assert.Len(t, counters.GetAll(), 3)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"test.forever"}, counterNames(counters))
+}
+
+func TestPrometheusCountersStaleRegistry(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"stale.ttl", 50,
+	))
+
+	registry := counters.Registry()
+	registry.AddCounter("requests_total", "", map[string]string{"code": "200"}, 1)
+	registry.AddCounter("requests_total", "", map[string]string{"code": "500"}, 1)
+
+	time.Sleep(100 * time.Millisecond)
+	registry.AddCounter("requests_total", "", map[string]string{"code": "200"}, 1)
+
+	body := pcount.PrometheusCounterConverter.FamiliesToString(counters.RegistryFamilies())
+	assert.Contains(t, body, "requests_total{code=\"200\"} 2\n")
+	assert.NotContains(t, body, "code=\"500\"")
+
+	// In mark mode stale series stay in the registry
+	assert.Len(t, registry.Families()[0].Samples, 2)
+}
+
+func TestPrometheusMetricsRegistryRemoveStale(t *testing.T) {
+	registry := pcount.NewPrometheusMetricsRegistry()
+	registry.SetGauge("gauge1", "", nil, 1)
+	registry.SetGauge("gauge2", "", nil, 1)
+
+	time.Sleep(100 * time.Millisecond)
+	ttl := func(name string) time.Duration {
+		if name == "gauge1" {
+			return 50 * time.Millisecond
+		}
+		return 0
+	}
+
+	assert.Len(t, registry.FreshFamilies(ttl), 1)
+	assert.Len(t, registry.Families(), 2)
+
+	assert.Equal(t, 1, registry.RemoveStale(ttl))
+	families := registry.Families()
+	assert.Len(t, families, 1)
+	assert.Equal(t, "gauge2", families[0].Name)
+}
+
+func TestPrometheusCountersStaleInvalidMode(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"stale.mode", "drop",
+	))
+
+	err := counters.Open("")
+	assert.NotNil(t, err)
+	assert.False(t, counters.IsOpen())
+}
@@ -0,0 +1,104 @@
+package test_count
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusCountersTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textfile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"source", "batch/job",
+		"instance", "host1",
+		"textfile.path", filepath.Join(dir, "{source}_{instance}.prom"),
+		"textfile.remove_on_close", true,
+	))
+	err = counters.Open("")
+	assert.Nil(t, err)
+
+	path := filepath.Join(dir, "batch_job_host1.prom")
+
+	counters.IncrementOne("test.counter1")
+	counters.Registry().SetGauge("last_run_seconds", "", nil, 5)
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "test_counter1 1\n")
+	assert.Contains(t, string(data), "last_run_seconds 5\n")
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	data, _ = ioutil.ReadFile(path)
+	assert.Contains(t, string(data), "test_counter1 2\n")
+
+	// No temporary files are left
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+
+	err = counters.Close("")
+	assert.Nil(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPrometheusCountersTextfileKeptOnClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textfile")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "job.prom")
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"textfile.path", path,
+	))
+	err = counters.Open("")
+	assert.Nil(t, err)
+
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	err = counters.Close("")
+	assert.Nil(t, err)
+	_, err = os.Stat(path)
+	assert.Nil(t, err)
+}
+
+func TestPrometheusCountersTextfileErrors(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"textfile.path", "/tmp/metrics.txt",
+	))
+	err := counters.Open("")
+	assert.NotNil(t, err)
+
+	counters = pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"textfile.path", "/not/existing/dir/metrics.prom",
+	))
+	err = counters.Open("")
+	assert.Nil(t, err)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.NotNil(t, err)
+}
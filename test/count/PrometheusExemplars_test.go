@@ -0,0 +1,79 @@
+package test_count
+
+import (
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusCountersExemplars(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+
+	timing := counters.BeginTimingWithCorrelation("123", "test.exec_time")
+	timing.EndTiming()
+	counters.IncrementWithCorrelation("456", "test.calls", 2)
+	counters.IncrementWithCorrelation("", "test.other", 1)
+
+	exemplars := counters.Exemplars()
+	assert.Len(t, exemplars, 2)
+	assert.Equal(t, "123", exemplars["test.exec_time"].Labels["trace_id"])
+	assert.Equal(t, "456", exemplars["test.calls"].Labels["trace_id"])
+	assert.Equal(t, float64(2), exemplars["test.calls"].Value)
+	assert.NotEqual(t, int64(0), exemplars["test.calls"].Timestamp)
+
+	// Timing is recorded as usual
+	found := false
+	for _, counter := range counters.GetAll() {
+		if counter.Name == "test.exec_time" {
+			found = true
+			assert.Equal(t, 1, counter.Count)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestPrometheusCountersTimingHistograms(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+
+	counters.BeginTiming("service1.command1.exec_time").EndTiming()
+	counters.BeginTimingWithCorrelation("123", "service1.command1.exec_time").EndTiming()
+
+	body := pcount.PrometheusCounterConverter.FamiliesToString(counters.Registry().Families())
+	assert.Contains(t, body, "# TYPE exec_time_seconds histogram\n")
+	assert.Contains(t, body, `exec_time_seconds_count{command="command1",service="service1"} 2`+"\n")
+
+	// Histograms can be disabled
+	counters = pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples("options.timing_histograms", false))
+	counters.BeginTiming("service1.command1.exec_time").EndTiming()
+	assert.Len(t, counters.Registry().Families(), 0)
+}
+func TestPrometheusMetricsRegistryExemplars(t *testing.T) {
+	registry := pcount.NewPrometheusMetricsRegistry()
+	buckets := []float64{0.1, 1}
+	labels := map[string]string{"method": "GET"}
+
+	registry.ObserveHistogramWithExemplar("duration_seconds", "", buckets, labels, 0.5,
+		&pcount.PrometheusExemplar{Labels: map[string]string{"trace_id": "123"}, Value: 0.5})
+	registry.ObserveHistogramWithExemplar("duration_seconds", "", buckets, labels, 5,
+		&pcount.PrometheusExemplar{Labels: map[string]string{"trace_id": "456"}, Value: 5})
+	registry.ObserveHistogram("duration_seconds", "", buckets, labels, 0.05)
+	registry.AddCounterWithExemplar("requests_total", "", nil, 1,
+		&pcount.PrometheusExemplar{Labels: map[string]string{"trace_id": "789"}, Value: 1})
+	// Increments without exemplars keep the last one
+	registry.AddCounter("requests_total", "", nil, 1)
+
+	body := pcount.PrometheusCounterConverter.FamiliesToOpenMetrics(registry.Families())
+	expected := "# TYPE duration_seconds histogram\n" +
+		"duration_seconds_bucket{le=\"0.1\",method=\"GET\"} 1\n" +
+		"duration_seconds_bucket{le=\"1\",method=\"GET\"} 2 # {trace_id=\"123\"} 0.5\n" +
+		"duration_seconds_bucket{le=\"+Inf\",method=\"GET\"} 3 # {trace_id=\"456\"} 5\n" +
+		"duration_seconds_sum{method=\"GET\"} 5.55\n" +
+		"duration_seconds_count{method=\"GET\"} 3\n" +
+		"# TYPE requests counter\n" +
+		"requests_total 2 # {trace_id=\"789\"} 1\n" +
+		"# EOF\n"
+	assert.Equal(t, expected, body)
+}
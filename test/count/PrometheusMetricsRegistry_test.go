@@ -0,0 +1,55 @@
+package test_count
+
+import (
+	"testing"
+
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetricsRegistryCountersAndGauges(t *testing.T) {
+	registry := pcount.NewPrometheusMetricsRegistry()
+
+	registry.AddCounter("requests_total", "Total requests", map[string]string{"code": "200"}, 1)
+	registry.AddCounter("requests_total", "Total requests", map[string]string{"code": "200"}, 2)
+	registry.AddCounter("requests_total", "Total requests", map[string]string{"code": "500"}, 1)
+	registry.AddCounter("requests_total", "Total requests", nil, -5)
+	registry.SetGauge("queue_length", "Queue length", nil, 5)
+	registry.AddGauge("queue_length", "Queue length", nil, -2)
+	// Different type for the same name is ignored
+	registry.SetGauge("requests_total", "", nil, 100)
+
+	body := pcount.PrometheusCounterConverter.FamiliesToString(registry.Families())
+	expected := "# HELP queue_length Queue length\n" +
+		"# TYPE queue_length gauge\n" +
+		"queue_length 3\n" +
+		"# HELP requests_total Total requests\n" +
+		"# TYPE requests_total counter\n" +
+		"requests_total{code=\"200\"} 3\n" +
+		"requests_total{code=\"500\"} 1\n"
+	assert.Equal(t, expected, body)
+}
+
+func TestPrometheusMetricsRegistryHistogram(t *testing.T) {
+	registry := pcount.NewPrometheusMetricsRegistry()
+
+	buckets := []float64{1, 0.1, 0.5, 1}
+	labels := map[string]string{"method": "GET"}
+	registry.ObserveHistogram("duration_seconds", "", buckets, labels, 0.05)
+	registry.ObserveHistogram("duration_seconds", "", buckets, labels, 0.3)
+	registry.ObserveHistogram("duration_seconds", "", buckets, labels, 2)
+
+	families := registry.Families()
+	assert.Len(t, families, 1)
+	assert.Equal(t, pcount.PrometheusHistogram, families[0].Type)
+
+	body := pcount.PrometheusCounterConverter.FamiliesToString(families)
+	expected := "# TYPE duration_seconds histogram\n" +
+		"duration_seconds_bucket{le=\"0.1\",method=\"GET\"} 1\n" +
+		"duration_seconds_bucket{le=\"0.5\",method=\"GET\"} 2\n" +
+		"duration_seconds_bucket{le=\"1\",method=\"GET\"} 2\n" +
+		"duration_seconds_bucket{le=\"+Inf\",method=\"GET\"} 3\n" +
+		"duration_seconds_sum{method=\"GET\"} 2.35\n" +
+		"duration_seconds_count{method=\"GET\"} 3\n"
+	assert.Equal(t, expected, body)
+}
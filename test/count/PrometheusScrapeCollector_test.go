@@ -0,0 +1,119 @@
+package test_count
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+// Discovery that resolves the same connections for all keys
+type staticDiscovery struct {
+	connections []*cconnect.ConnectionParams
+}
+
+func (c *staticDiscovery) Register(correlationId string, key string,
+	connection *cconnect.ConnectionParams) (*cconnect.ConnectionParams, error) {
+	c.connections = append(c.connections, connection)
+	return connection, nil
+}
+
+func (c *staticDiscovery) ResolveOne(correlationId string, key string) (*cconnect.ConnectionParams, error) {
+	if len(c.connections) == 0 {
+		return nil, nil
+	}
+	return c.connections[0], nil
+}
+
+func (c *staticDiscovery) ResolveAll(correlationId string, key string) ([]*cconnect.ConnectionParams, error) {
+	return c.connections, nil
+}
+
+func TestPrometheusScrapeCollector(t *testing.T) {
+	sidecar := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		res.Write([]byte("# TYPE envoy_requests_total counter\n" +
+			"envoy_requests_total{target=\"backend\"} 5\n"))
+	}))
+	defer sidecar.Close()
+
+	legacy := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/custom", req.URL.Path)
+		res.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0")
+		res.Write([]byte("# TYPE queue_length gauge\nqueue_length 3\n# EOF\n"))
+	}))
+	defer legacy.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	legacyUrl, _ := url.Parse(legacy.URL)
+	port, _ := strconv.Atoi(legacyUrl.Port())
+	discovery := &staticDiscovery{}
+	discovery.Register("", "metrics", cconnect.NewConnectionParamsFromTuples(
+		"host", legacyUrl.Hostname(),
+		"port", port,
+		"labels.path", "/custom",
+	))
+
+	collector := pcount.NewPrometheusScrapeCollector()
+	collector.Configure(cconf.NewConfigParamsFromTuples(
+		"discovery_keys", "metrics",
+		"targets.envoy.uri", sidecar.URL+"/metrics",
+		"targets.broken.uri", broken.URL+"/metrics",
+		"options.interval", 60000,
+	))
+	collector.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "discovery", "memory", "default", "1.0"), discovery,
+	))
+
+	// Nothing is scraped until the collector is opened
+	collector.Scrape("")
+	assert.Len(t, pcount.CollectMetricFamilies([]pcount.ICollector{collector}), 0)
+
+	err := collector.Open("")
+	assert.Nil(t, err)
+	collector.Scrape("")
+
+	families := pcount.CollectMetricFamilies([]pcount.ICollector{collector})
+	byName := make(map[string]*pcount.PrometheusMetricFamily)
+	for _, family := range families {
+		byName[family.Name] = family
+	}
+	assert.Len(t, families, 4)
+
+	family := byName["envoy_requests_total"]
+	if assert.NotNil(t, family) && assert.Len(t, family.Samples, 1) {
+		assert.Equal(t, pcount.PrometheusCounter, family.Type)
+		assert.Equal(t, map[string]string{"target": "envoy", "exported_target": "backend"}, family.Samples[0].Labels)
+		assert.Equal(t, 5.0, family.Samples[0].Value)
+	}
+
+	family = byName["queue_length"]
+	if assert.NotNil(t, family) && assert.Len(t, family.Samples, 1) {
+		assert.Equal(t, legacyUrl.Host, family.Samples[0].Labels["target"])
+	}
+
+	family = byName["prometheus_proxy_target_up"]
+	if assert.NotNil(t, family) {
+		up := make(map[string]float64)
+		for _, sample := range family.Samples {
+			up[sample.Labels["target"]] = sample.Value
+		}
+		assert.Equal(t, map[string]float64{"envoy": 1, "broken": 0, legacyUrl.Host: 1}, up)
+	}
+	assert.NotNil(t, byName["prometheus_proxy_scrape_duration_seconds"])
+
+	err = collector.Close("")
+	assert.Nil(t, err)
+	assert.Len(t, pcount.CollectMetricFamilies([]pcount.ICollector{collector}), 0)
+}
@@ -0,0 +1,92 @@
+package test_count
+
+import (
+	"testing"
+
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSample(name string, labels map[string]string) *pcount.PrometheusSample {
+	return &pcount.PrometheusSample{Name: name, Labels: labels, Value: 1}
+}
+
+func TestParsePrometheusSeriesSelector(t *testing.T) {
+	selector, err := pcount.ParsePrometheusSeriesSelector(`http_requests_total`)
+	assert.Nil(t, err)
+	assert.Len(t, selector.Matchers, 1)
+	assert.Equal(t, "__name__", selector.Matchers[0].Name)
+	assert.Equal(t, "http_requests_total", selector.Matchers[0].Value)
+
+	selector, err = pcount.ParsePrometheusSeriesSelector(` job:requests:rate5m { method = "GET", code=~'5..' ,route!="/health", host!~` + "`a\\.b`" + `, } `)
+	assert.Nil(t, err)
+	assert.Len(t, selector.Matchers, 5)
+	assert.Equal(t, "job:requests:rate5m", selector.Matchers[0].Value)
+	assert.Equal(t, pcount.PrometheusMatchEqual, selector.Matchers[1].Type)
+	assert.Equal(t, pcount.PrometheusMatchRegexp, selector.Matchers[2].Type)
+	assert.Equal(t, "5..", selector.Matchers[2].Value)
+	assert.Equal(t, pcount.PrometheusMatchNotEqual, selector.Matchers[3].Type)
+	assert.Equal(t, pcount.PrometheusMatchNotRegexp, selector.Matchers[4].Type)
+	assert.Equal(t, `a\.b`, selector.Matchers[4].Value)
+
+	selector, err = pcount.ParsePrometheusSeriesSelector(`{__name__=~"job_.*", path="a\"b\\c"}`)
+	assert.Nil(t, err)
+	assert.Len(t, selector.Matchers, 2)
+	assert.Equal(t, `a"b\c`, selector.Matchers[1].Value)
+}
+
+func TestParseInvalidPrometheusSeriesSelector(t *testing.T) {
+	invalid := []string{
+		``,
+		`{}`,
+		`{code=~".*"}`,
+		`metric{code}`,
+		`metric{code="200"`,
+		`metric{code=200}`,
+		`metric{code="200}`,
+		`metric{code=~"("}`,
+		`metric{code="200"} extra`,
+		`metric{code=="200"}`,
+	}
+
+	for _, text := range invalid {
+		_, err := pcount.ParsePrometheusSeriesSelector(text)
+		assert.NotNil(t, err, text)
+	}
+}
+
+func TestPrometheusSeriesSelectorMatches(t *testing.T) {
+	selector, err := pcount.ParsePrometheusSeriesSelector(`http_requests_total{code=~"5..",method!="POST",route=""}`)
+	assert.Nil(t, err)
+
+	assert.True(t, selector.Matches(newSample("http_requests_total", map[string]string{"code": "500", "method": "GET"})))
+	assert.True(t, selector.Matches(newSample("http_requests_total", map[string]string{"code": "503"})))
+	assert.False(t, selector.Matches(newSample("http_requests_total", map[string]string{"code": "500", "method": "POST"})))
+	assert.False(t, selector.Matches(newSample("http_requests_total", map[string]string{"code": "5000"})))
+	assert.False(t, selector.Matches(newSample("http_requests_total", map[string]string{"code": "500", "route": "/a"})))
+	assert.False(t, selector.Matches(newSample("http_requests", map[string]string{"code": "500"})))
+}
+
+func TestFilterMetricFamilies(t *testing.T) {
+	family1 := pcount.NewPrometheusMetricFamily("jobs_total", pcount.PrometheusCounter, "Jobs")
+	family1.AddSample("jobs_total", map[string]string{"queue": "a"}, 1)
+	family1.AddSample("jobs_total", map[string]string{"queue": "b"}, 2)
+	family2 := pcount.NewPrometheusMetricFamily("queue_length", pcount.PrometheusGauge, "Length")
+	family2.AddSample("queue_length", map[string]string{"queue": "a"}, 3)
+	family3 := pcount.NewPrometheusMetricFamily("other", pcount.PrometheusGauge, "")
+	family3.AddSample("other", nil, 4)
+
+	selector1, _ := pcount.ParsePrometheusSeriesSelector(`jobs_total{queue="b"}`)
+	selector2, _ := pcount.ParsePrometheusSeriesSelector(`{__name__=~"queue_.*|jobs_total",queue="a"}`)
+
+	families := pcount.FilterMetricFamilies([]*pcount.PrometheusMetricFamily{family1, family2, family3},
+		[]*pcount.PrometheusSeriesSelector{selector1, selector2})
+	text := pcount.PrometheusCounterConverter.FamiliesToString(families)
+
+	assert.Len(t, families, 2)
+	assert.Contains(t, text, "# TYPE jobs_total counter\n")
+	assert.Contains(t, text, `jobs_total{queue="a"} 1`)
+	assert.Contains(t, text, `jobs_total{queue="b"} 2`)
+	assert.Contains(t, text, `queue_length{queue="a"} 3`)
+	assert.NotContains(t, text, "other")
+}
@@ -0,0 +1,127 @@
+package test_count
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusTransportOptionsDefaults(t *testing.T) {
+	options := pcount.NewPrometheusTransportOptions()
+
+	transport, err := options.CreateTransport("")
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 90*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 100, transport.MaxIdleConns)
+	assert.True(t, transport.ForceAttemptHTTP2)
+	assert.Nil(t, transport.TLSNextProto)
+	assert.NotNil(t, transport.Proxy)
+}
+
+func TestPrometheusTransportOptionsConfigure(t *testing.T) {
+	options := pcount.NewPrometheusTransportOptions()
+	options.Configure(cconf.NewConfigParamsFromTuples(
+		"options.connect_timeout", 3000,
+		"options.tls_handshake_timeout", 4000,
+		"options.keep_alive", 0,
+		"options.idle_timeout", 5000,
+		"options.max_idle_connections", 10,
+		"options.max_idle_connections_per_host", 5,
+		"options.max_connections_per_host", 7,
+		"options.proxy_from_env", false,
+		"options.http2", false,
+	))
+
+	assert.Equal(t, 3000, options.ConnectTimeout)
+
+	transport, err := options.CreateTransport("")
+	assert.Nil(t, err)
+	assert.Equal(t, 4*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 5*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 10, transport.MaxIdleConns)
+	assert.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 7, transport.MaxConnsPerHost)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.NotNil(t, transport.TLSNextProto)
+	assert.Len(t, transport.TLSNextProto, 0)
+	assert.Nil(t, transport.Proxy)
+}
+
+func TestPrometheusTransportOptionsLegacyConnectTimeout(t *testing.T) {
+	options := pcount.NewPrometheusTransportOptions()
+	options.Configure(cconf.NewConfigParamsFromTuples(
+		"options.connectTimeout", 2000,
+	))
+	assert.Equal(t, 2000, options.ConnectTimeout)
+
+	options.Configure(cconf.NewConfigParamsFromTuples(
+		"options.connectTimeout", 2000,
+		"options.connect_timeout", 1000,
+	))
+	assert.Equal(t, 1000, options.ConnectTimeout)
+}
+
+func TestPrometheusTransportOptionsInvalidProxy(t *testing.T) {
+	options := pcount.NewPrometheusTransportOptions()
+	options.Configure(cconf.NewConfigParamsFromTuples(
+		"options.proxy", "not a proxy",
+	))
+
+	_, err := options.CreateTransport("")
+	assert.NotNil(t, err)
+}
+
+func TestPrometheusCountersPushThroughProxy(t *testing.T) {
+	var proxiedUrl string
+	var proxiedBody string
+	proxy := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		// Proxied requests carry the absolute URL of the target
+		proxiedUrl = req.URL.String()
+		body, _ := ioutil.ReadAll(req.Body)
+		proxiedBody = string(body)
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"source", "test",
+		"instance", "host1",
+		"connection.protocol", "http",
+		"connection.host", "pushgateway.local",
+		"connection.port", 9091,
+		"options.proxy", proxy.URL,
+	))
+
+	err := counters.Open("")
+	assert.Nil(t, err)
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "http://pushgateway.local:9091/metrics/job/test/instance/host1", proxiedUrl)
+	assert.Contains(t, proxiedBody, "test_counter1 1")
+}
+
+func TestPrometheusCountersOpenWithInvalidProxy(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"connection.protocol", "http",
+		"connection.host", "localhost",
+		"connection.port", 9091,
+		"options.proxy", "://",
+	))
+
+	err := counters.Open("")
+	assert.NotNil(t, err)
+	assert.False(t, counters.IsOpen())
+}
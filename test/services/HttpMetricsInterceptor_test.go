@@ -0,0 +1,136 @@
+package test_services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	rpcservices "github.com/pip-services3-go/pip-services3-rpc-go/services"
+	"github.com/stretchr/testify/assert"
+)
+
+// REST service with a parameterized route to instrument
+type itemsRestService struct {
+	rpcservices.RestService
+}
+
+func newItemsRestService() *itemsRestService {
+	c := &itemsRestService{}
+	c.RestService = *rpcservices.InheritRestService(c)
+	return c
+}
+
+func (c *itemsRestService) Register() {
+	c.RegisterRoute("get", "/items/{id}", nil, func(res http.ResponseWriter, req *http.Request) {
+		if c.GetParam(req, "id") == "missing" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res.Write([]byte("item " + c.GetParam(req, "id")))
+	})
+	c.RegisterRoute("post", "/items", nil, func(res http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		res.WriteHeader(http.StatusCreated)
+		res.Write(data)
+	})
+}
+
+func sendRequest(t *testing.T, method string, url string, body string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	if res != nil {
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+}
+
+func registryText(counters *pcount.PrometheusCounters) string {
+	return pcount.PrometheusCounterConverter.FamiliesToString(counters.Registry().Families())
+}
+
+func TestHttpMetricsInterceptorOwnEndpoint(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	service := newItemsRestService()
+	service.Configure(newServiceConfig("3015"))
+
+	interceptor := pservice.NewHttpMetricsInterceptor()
+	references := cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "counters", "prometheus", "default", "1.0"), counters,
+		cref.NewDescriptor("pip-services", "interceptor", "http", "default", "1.0"), interceptor,
+	)
+	service.SetReferences(references)
+	interceptor.SetReferences(references)
+	interceptor.Instrument(service.Endpoint)
+
+	err := service.Open("")
+	assert.Nil(t, err)
+	defer service.Close("")
+	waitForEndpoint(t, "3015")
+
+	sendRequest(t, "GET", "http://localhost:3015/items/1", "")
+	sendRequest(t, "GET", "http://localhost:3015/items/2", "")
+	sendRequest(t, "GET", "http://localhost:3015/items/missing", "")
+	sendRequest(t, "POST", "http://localhost:3015/items", "new item")
+	sendRequest(t, "GET", "http://localhost:3015/unknown", "")
+
+	text := registryText(counters)
+	assert.Contains(t, text, `http_requests_total{code="200",method="GET",route="/items/{id}"} 2`)
+	assert.Contains(t, text, `http_requests_total{code="404",method="GET",route="/items/{id}"} 1`)
+	assert.Contains(t, text, `http_requests_total{code="201",method="POST",route="/items"} 1`)
+	assert.NotContains(t, text, "unknown")
+	assert.NotContains(t, text, "/items/1")
+
+	assert.Contains(t, text, "# TYPE http_request_duration_seconds histogram\n")
+	assert.Contains(t, text, `http_request_duration_seconds_count{method="GET",route="/items/{id}"} 3`)
+	assert.Contains(t, text, `http_request_size_bytes_sum{method="POST",route="/items"} 8`)
+	assert.Contains(t, text, `http_response_size_bytes_sum{method="POST",route="/items"} 8`)
+	assert.Contains(t, text, `http_response_size_bytes_sum{method="GET",route="/items/{id}"} 12`)
+}
+
+func TestHttpMetricsInterceptorSharedEndpoint(t *testing.T) {
+	endpoint := rpcservices.NewHttpEndpoint()
+	endpoint.Configure(newServiceConfig("3016"))
+
+	counters := pcount.NewPrometheusCounters()
+	service := newItemsRestService()
+	interceptor := pservice.NewHttpMetricsInterceptor()
+	interceptor.Configure(newServiceConfig("3016",
+		"options.duration_buckets", "0.5, 1, 5",
+	))
+
+	references := cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "endpoint", "http", "default", "1.0"), endpoint,
+		cref.NewDescriptor("pip-services", "counters", "prometheus", "default", "1.0"), counters,
+		cref.NewDescriptor("pip-services", "service", "items", "default", "1.0"), service,
+		cref.NewDescriptor("pip-services", "metrics-interceptor", "http", "default", "1.0"), interceptor,
+	)
+	service.SetReferences(references)
+	interceptor.SetReferences(references)
+
+	err := endpoint.Open("")
+	assert.Nil(t, err)
+	defer endpoint.Close("")
+	err = service.Open("")
+	assert.Nil(t, err)
+	defer service.Close("")
+	waitForEndpoint(t, "3016")
+
+	sendRequest(t, "GET", "http://localhost:3016/items/1", "")
+
+	text := registryText(counters)
+	assert.Contains(t, text, `http_requests_total{code="200",method="GET",route="/items/{id}"} 1`)
+	assert.Contains(t, text, `http_request_duration_seconds_bucket{le="0.5",method="GET",route="/items/{id}"} 1`)
+	assert.Contains(t, text, `http_request_duration_seconds_bucket{le="+Inf",method="GET",route="/items/{id}"} 1`)
+
+	sendRequest(t, "GET", "http://localhost:3016/items/2?correlation_id=123", "")
+
+	text = pcount.PrometheusCounterConverter.FamiliesToOpenMetrics(counters.Registry().Families())
+	assert.Contains(t, text, `http_requests_total{code="200",method="GET",route="/items/{id}"} 2 # {trace_id="123"} 1 `)
+	assert.Contains(t, text, `http_request_duration_seconds_bucket{le="0.5",method="GET",route="/items/{id}"} 2 # {trace_id="123"} `)
+}
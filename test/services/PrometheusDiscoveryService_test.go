@@ -0,0 +1,73 @@
+package test_services
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusDiscoveryService(t *testing.T) {
+	discovery := newRegistryDiscovery()
+	for _, host := range []string{"host2", "host1"} {
+		discovery.Register("", "metrics", cconnect.NewConnectionParamsFromTuples(
+			"protocol", "http",
+			"host", host,
+			"port", 8080,
+			"labels.source", "orders",
+			"labels.scheme", "http",
+			"labels.path", "/metrics",
+		))
+	}
+	// Connections without host and port can't be scraped
+	discovery.Register("", "metrics", cconnect.NewConnectionParamsFromTuples("labels.source", "broken"))
+
+	service := pservice.NewPrometheusDiscoveryService()
+	service.Configure(cconf.NewConfigParamsFromTuples(
+		"connection.protocol", "http",
+		"connection.host", "localhost",
+		"connection.port", "3026",
+		"discovery_keys", "metrics, other",
+		"targets.gateway.uri", "https://pushgateway:9091/gateway/metrics",
+		"targets.gateway.labels.job", "pushgateway",
+	))
+	service.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "discovery", "memory", "default", "1.0"), discovery,
+	))
+
+	err := service.Open("")
+	assert.Nil(t, err)
+	defer service.Close("")
+	waitForEndpoint(t, "3026")
+
+	res, err := http.Get("http://localhost:3026/targets")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var groups []*pservice.PrometheusTargetGroup
+	err = json.NewDecoder(res.Body).Decode(&groups)
+	assert.Nil(t, err)
+
+	if assert.Len(t, groups, 2) {
+		assert.Equal(t, []string{"host1:8080", "host2:8080"}, groups[0].Targets)
+		assert.Equal(t, map[string]string{
+			"source":           "orders",
+			"__scheme__":       "http",
+			"__metrics_path__": "/metrics",
+		}, groups[0].Labels)
+
+		assert.Equal(t, []string{"pushgateway:9091"}, groups[1].Targets)
+		assert.Equal(t, map[string]string{
+			"job":              "pushgateway",
+			"__scheme__":       "https",
+			"__metrics_path__": "/gateway/metrics",
+		}, groups[1].Labels)
+	}
+}
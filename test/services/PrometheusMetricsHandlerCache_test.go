@@ -0,0 +1,126 @@
+package test_services
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	"github.com/stretchr/testify/assert"
+)
+
+// Counts collections to check that scrapes are coalesced
+type countingCollector struct {
+	calls int32
+	delay time.Duration
+}
+
+func (c *countingCollector) Collect(ch chan<- *pcount.PrometheusMetricFamily) {
+	calls := atomic.AddInt32(&c.calls, 1)
+	time.Sleep(c.delay)
+	family := pcount.NewPrometheusMetricFamily("collections", pcount.PrometheusGauge, "")
+	family.AddSample("collections", nil, float64(calls))
+	ch <- family
+}
+
+func TestPrometheusMetricsHandlerCoalescing(t *testing.T) {
+	collector := &countingCollector{delay: 200 * time.Millisecond}
+	handler := pservice.NewPrometheusMetricsHandler()
+	handler.AddCollectors(collector)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for index := range bodies {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			// Helpers that stop the test can't be used outside of the test goroutine
+			res, err := http.Get(server.URL)
+			if assert.Nil(t, err) {
+				body, _ := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				bodies[index] = string(body)
+			}
+		}(index)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&collector.calls))
+	for _, body := range bodies {
+		assert.Contains(t, body, "collections 1\n")
+	}
+
+	// Without cache the next scrape collects again
+	_, body := scrape(t, server.URL)
+	assert.Contains(t, body, "collections 2\n")
+	assert.Contains(t, body, "prometheus_metrics_scrape_coalesced_total 4\n")
+}
+
+func TestPrometheusMetricsHandlerCoalescingTimeouts(t *testing.T) {
+	collector := &countingCollector{delay: 200 * time.Millisecond}
+	handler := pservice.NewPrometheusMetricsHandler()
+	handler.AddCollectors(collector)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// The scrape that started the collection gives up before it completes
+	var wg sync.WaitGroup
+	status := 0
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.05")
+		client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		res, err := client.Do(req)
+		if assert.Nil(t, err) {
+			status = res.StatusCode
+			res.Body.Close()
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Scrape with a longer timeout still gets the shared collection
+	res, body := scrape(t, server.URL, "X-Prometheus-Scrape-Timeout-Seconds", "2")
+	wg.Wait()
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, body, "collections 1\n")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&collector.calls))
+}
+
+func TestPrometheusMetricsHandlerCache(t *testing.T) {
+	collector := &countingCollector{}
+	handler := pservice.NewPrometheusMetricsHandler()
+	handler.Configure(cconf.NewConfigParamsFromTuples("options.cache_ttl", 300))
+	handler.AddCollectors(collector)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, body1 := scrape(t, server.URL)
+	_, body2 := scrape(t, server.URL)
+	assert.Equal(t, body1, body2)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&collector.calls))
+
+	// Compressed payloads are cached separately
+	res, _ := scrape(t, server.URL, "Accept-Encoding", "gzip")
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&collector.calls))
+
+	time.Sleep(400 * time.Millisecond)
+	_, body := scrape(t, server.URL)
+	assert.Contains(t, body, "collections 3\n")
+	assert.Contains(t, body, "prometheus_metrics_scrape_cache_hits_total 1\n")
+	assert.Contains(t, body, "prometheus_metrics_scrape_cache_misses_total 3\n")
+}
@@ -0,0 +1,186 @@
+package test_services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetricsHandler(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.IncrementOne("test.counter1")
+
+	handler := pservice.NewPrometheusMetricsHandler(counters)
+	handler.SetLabels("app", "host1")
+	handler.AddCollectors(&queueCollector{queue: "queue1", length: 5})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	mux.Handle("/federate", handler.FederateHandler())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	res, body := scrape(t, server.URL+"/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4", res.Header.Get("Content-Type"))
+	assert.Contains(t, body, `test_counter1{instance="host1",source="app"} 1`)
+	assert.Contains(t, body, `queue_length{instance="host1",queue="queue1",source="app"} 5`)
+
+	res, body = scrape(t, server.URL+"/federate?match[]="+url.QueryEscape("queue_length"))
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "queue_length{")
+	assert.NotContains(t, body, "test_counter1")
+}
+
+func TestPrometheusMetricsHandlerSeveralCounters(t *testing.T) {
+	counters1 := pcount.NewPrometheusCounters()
+	counters1.IncrementOne("test.counter1")
+	counters2 := pcount.NewPrometheusCounters()
+	counters2.Increment("test.counter1", 2)
+
+	handler := pservice.NewPrometheusMetricsHandler(counters1, counters2)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, body := scrape(t, server.URL)
+	assert.Contains(t, body, `test_counter1{counters="prometheus"} 1`)
+	assert.Contains(t, body, `test_counter1{counters="prometheus_1"} 2`)
+}
+
+func TestPrometheusMetricsHandlerRelays(t *testing.T) {
+	sidecar := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("# TYPE envoy_requests_total counter\n" +
+			"envoy_requests_total{instance=\"sidecar1\"} 5\n"))
+	}))
+	defer sidecar.Close()
+
+	collector := pcount.NewPrometheusScrapeCollector()
+	collector.Configure(cconf.NewConfigParamsFromTuples(
+		"targets.envoy.uri", sidecar.URL+"/metrics",
+		"options.interval", 60000,
+	))
+	err := collector.Open("")
+	assert.Nil(t, err)
+	defer collector.Close("")
+	collector.Scrape("")
+
+	handler := pservice.NewPrometheusMetricsHandler()
+	handler.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "metrics-relay", "scrape", "default", "1.0"), collector,
+	))
+	handler.SetLabels("app", "host1")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// The scraped instance is kept as exported_instance
+	_, body := scrape(t, server.URL)
+	assert.Contains(t, body,
+		`envoy_requests_total{exported_instance="sidecar1",instance="host1",source="app",target="envoy"} 5`)
+	assert.Contains(t, body, `prometheus_proxy_target_up{instance="host1",source="app",target="envoy"} 1`)
+}
+
+func TestPrometheusMetricsHandlerAccessControl(t *testing.T) {
+	handler := pservice.NewPrometheusMetricsHandler()
+	handler.Configure(cconf.NewConfigParamsFromTuples(
+		"credential.access_key", "token123",
+	))
+	handler.SetReferences(cref.NewEmptyReferences())
+	err := handler.Open("")
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	res, _ := scrape(t, server.URL)
+	assert.Equal(t, 401, res.StatusCode)
+
+	res, body := scrape(t, server.URL, "Authorization", "Bearer token123")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, `prometheus_metrics_scrape_rejected_total{reason="unauthorized"} 1`)
+}
+
+func TestPrometheusMetricsHandlerOpenMetrics(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	counters.IncrementWithCorrelation("123", "test.counter1", 1)
+
+	handler := pservice.NewPrometheusMetricsHandler(counters)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	accept := "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5"
+
+	// OpenMetrics is disabled by default
+	res, body := scrape(t, server.URL, "Accept", accept)
+	assert.Equal(t, "text/plain; version=0.0.4", res.Header.Get("Content-Type"))
+	assert.NotContains(t, body, "trace_id")
+
+	handler.Configure(cconf.NewConfigParamsFromTuples("options.openmetrics", true))
+
+	res, body = scrape(t, server.URL, "Accept", accept)
+	assert.Equal(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE test_counter1 counter\n")
+	assert.Contains(t, body, `test_counter1_total 1 # {trace_id="123"} 1 `)
+	assert.Contains(t, body, "# EOF\n")
+
+	res, body = scrape(t, server.URL, "Accept", "text/plain")
+	assert.Equal(t, "text/plain; version=0.0.4", res.Header.Get("Content-Type"))
+	assert.NotContains(t, body, "# EOF")
+}
+
+func TestPrometheusMetricsHandlerTimingExemplars(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	timing := counters.BeginTimingWithCorrelation("789", "myservice.mycommand.exec_time")
+	timing.EndTiming()
+
+	handler := pservice.NewPrometheusMetricsHandler(counters)
+	handler.Configure(cconf.NewConfigParamsFromTuples("options.openmetrics", true))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, body := scrape(t, server.URL, "Accept", "application/openmetrics-text;version=1.0.0")
+	assert.Contains(t, body, "# TYPE exec_time_seconds histogram\n")
+	assert.Regexp(t, `exec_time_seconds_bucket\{command="mycommand",le="[^"]+",service="myservice"\} 1 # \{trace_id="789"\} `, body)
+	assert.Contains(t, body, `exec_time_seconds_count{command="mycommand",service="myservice"} 1`+"\n")
+}
+
+func TestPrometheusMetricsHandlerNotifiesScrapes(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	address, _ := url.Parse(gateway.URL)
+
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"connection.uri", "http://"+address.Host,
+		"push.mode", "fallback",
+		"push.scrape_window", 100,
+	))
+	err := counters.Open("")
+	assert.Nil(t, err)
+	defer counters.Close("")
+
+	handler := pservice.NewPrometheusMetricsHandler(counters)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Pushes start when there are no scrapes
+	time.Sleep(150 * time.Millisecond)
+	counters.IncrementOne("test.counter1")
+	assert.Nil(t, counters.Save(counters.GetAll()))
+
+	scrape(t, server.URL)
+	assert.Nil(t, counters.Save(counters.GetAll()))
+
+	_, body := scrape(t, server.URL)
+	assert.Contains(t, body, `prometheus_counters_push_attempts_total 2`)
+	assert.Contains(t, body, `prometheus_counters_push_last_success_timestamp_seconds`)
+}
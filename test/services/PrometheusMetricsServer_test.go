@@ -0,0 +1,71 @@
+package test_services
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cinfo "github.com/pip-services3-go/pip-services3-components-go/info"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetricsServer(t *testing.T) {
+	counters := pcount.NewPrometheusCounters()
+	contextInfo := cinfo.NewContextInfo()
+	contextInfo.Name = "Test"
+
+	server := pservice.NewPrometheusMetricsServer()
+	server.Configure(cconf.NewConfigParamsFromTuples(
+		"route", "internal/metrics",
+		"connection.protocol", "http",
+		"connection.host", "localhost",
+		"connection.port", "3022",
+		"options.federate", true,
+	))
+	server.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "context-info", "default", "default", "1.0"), contextInfo,
+		cref.NewDescriptor("pip-services", "counters", "prometheus", "default", "1.0"), counters,
+	))
+
+	err := server.Open("")
+	assert.Nil(t, err)
+	assert.True(t, server.IsOpen())
+	assert.True(t, strings.HasSuffix(server.Address(), ":3022"))
+
+	counters.IncrementOne("test.counter1")
+
+	res, body := scrape(t, "http://localhost:3022/internal/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, `test_counter1{instance="`)
+	assert.Contains(t, body, `source="Test"} 1`)
+
+	res, _ = scrape(t, "http://localhost:3022/federate?match[]=test_counter1")
+	assert.Equal(t, 200, res.StatusCode)
+
+	res, _ = scrape(t, "http://localhost:3022/metrics")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	err = server.Close("")
+	assert.Nil(t, err)
+	assert.False(t, server.IsOpen())
+
+	_, err = http.Get("http://localhost:3022/internal/metrics")
+	assert.NotNil(t, err)
+}
+
+func TestPrometheusMetricsServerUnsupportedProtocol(t *testing.T) {
+	server := pservice.NewPrometheusMetricsServer()
+	server.Configure(cconf.NewConfigParamsFromTuples(
+		"connection.protocol", "udp",
+		"connection.host", "localhost",
+		"connection.port", "3023",
+	))
+
+	err := server.Open("")
+	assert.NotNil(t, err)
+	assert.False(t, server.IsOpen())
+}
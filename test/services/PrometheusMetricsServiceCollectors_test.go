@@ -0,0 +1,66 @@
+package test_services
+
+import (
+	"testing"
+	"time"
+
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+type queueCollector struct {
+	queue  string
+	length float64
+	delay  time.Duration
+}
+
+func (c *queueCollector) Collect(ch chan<- *pcount.PrometheusMetricFamily) {
+	time.Sleep(c.delay)
+	family := pcount.NewPrometheusMetricFamily("queue_length", pcount.PrometheusGauge, "Number of messages in the queue")
+	family.AddSample("queue_length", map[string]string{"queue": c.queue}, c.length)
+	ch <- family
+}
+
+func TestPrometheusMetricsServiceCollectors(t *testing.T) {
+	collector1 := &queueCollector{queue: "queue1", length: 5}
+	collector2 := &queueCollector{queue: "queue2", length: 7}
+
+	service, counters := openMetricsServiceWithReferences(t, "3010", []interface{}{
+		cref.NewDescriptor("pip-services", "metrics-collector", "queue", "queue1", "1.0"), collector1,
+		cref.NewDescriptor("pip-services", "metrics-collector", "queue", "queue2", "1.0"), collector2,
+	})
+	defer service.Close("")
+	defer counters.Close("")
+
+	res, body := scrape(t, "http://localhost:3010/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, `queue_length{instance="`)
+	assert.Contains(t, body, `queue="queue1",source="Test"} 5`)
+	assert.Contains(t, body, `queue="queue2",source="Test"} 7`)
+
+	// Both collectors contribute to one family
+	assert.Equal(t, 1, countOccurrences(body, "# TYPE queue_length gauge"))
+
+	// Values are computed on every scrape
+	collector1.length = 10
+	_, body = scrape(t, "http://localhost:3010/metrics")
+	assert.Contains(t, body, `queue="queue1",source="Test"} 10`)
+}
+
+func TestPrometheusMetricsServiceCollectorTimeout(t *testing.T) {
+	collector := &queueCollector{queue: "queue1", length: 5, delay: 500 * time.Millisecond}
+
+	service, counters := openMetricsServiceWithReferences(t, "3011", []interface{}{
+		cref.NewDescriptor("pip-services", "metrics-collector", "queue", "default", "1.0"), collector,
+	})
+	defer service.Close("")
+	defer counters.Close("")
+
+	res, _ := scrape(t, "http://localhost:3011/metrics", "X-Prometheus-Scrape-Timeout-Seconds", "0.05")
+	assert.Equal(t, 503, res.StatusCode)
+
+	res, body := scrape(t, "http://localhost:3011/metrics", "X-Prometheus-Scrape-Timeout-Seconds", "2")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "queue_length")
+}
@@ -0,0 +1,48 @@
+package test_services
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetricsServiceFederate(t *testing.T) {
+	service, counters := openMetricsService(t, "3020",
+		"options.federate", true,
+	)
+	defer service.Close("")
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	counters.IncrementOne("test.counter2")
+	counters.Registry().AddCounter("jobs_total", "Total number of jobs", map[string]string{"queue": "a"}, 1)
+	counters.Registry().AddCounter("jobs_total", "Total number of jobs", map[string]string{"queue": "b"}, 2)
+
+	query := url.Values{}
+	query.Add("match[]", `test_counter1`)
+	query.Add("match[]", `jobs_total{queue=~"b|c"}`)
+
+	res, body := scrape(t, "http://localhost:3020/federate?"+query.Encode())
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "test_counter1{")
+	assert.NotContains(t, body, "test_counter2")
+	assert.Contains(t, body, `queue="b"`)
+	assert.NotContains(t, body, `queue="a"`)
+
+	res, body = scrape(t, "http://localhost:3020/federate")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "", body)
+
+	res, _ = scrape(t, "http://localhost:3020/federate?match[]="+url.QueryEscape(`{code=~".*"}`))
+	assert.Equal(t, 400, res.StatusCode)
+}
+
+func TestPrometheusMetricsServiceFederateDisabled(t *testing.T) {
+	service, counters := openMetricsService(t, "3021")
+	defer service.Close("")
+	defer counters.Close("")
+
+	res, _ := scrape(t, "http://localhost:3021/federate?match[]=test_counter1")
+	assert.Equal(t, 404, res.StatusCode)
+}
@@ -0,0 +1,260 @@
+package test_services
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cerr "github.com/pip-services3-go/pip-services3-commons-go/errors"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cconnect "github.com/pip-services3-go/pip-services3-components-go/connect"
+	cinfo "github.com/pip-services3-go/pip-services3-components-go/info"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	rpcservices "github.com/pip-services3-go/pip-services3-rpc-go/services"
+	"github.com/stretchr/testify/assert"
+)
+
+// In-memory discovery that supports deregistration
+type registryDiscovery struct {
+	lock        sync.Mutex
+	connections map[string][]*cconnect.ConnectionParams
+}
+
+func newRegistryDiscovery() *registryDiscovery {
+	return &registryDiscovery{connections: make(map[string][]*cconnect.ConnectionParams)}
+}
+
+func (c *registryDiscovery) Register(correlationId string, key string,
+	connection *cconnect.ConnectionParams) (*cconnect.ConnectionParams, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.connections[key] = append(c.connections[key], connection)
+	return connection, nil
+}
+
+func (c *registryDiscovery) ResolveOne(correlationId string, key string) (*cconnect.ConnectionParams, error) {
+	connections, _ := c.ResolveAll(correlationId, key)
+	if len(connections) == 0 {
+		return nil, nil
+	}
+	return connections[0], nil
+}
+
+func (c *registryDiscovery) ResolveAll(correlationId string, key string) ([]*cconnect.ConnectionParams, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	result := make([]*cconnect.ConnectionParams, len(c.connections[key]))
+	copy(result, c.connections[key])
+	return result, nil
+}
+
+func (c *registryDiscovery) Deregister(correlationId string, key string, connection *cconnect.ConnectionParams) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	connections := c.connections[key]
+	for index, item := range connections {
+		if item == connection {
+			c.connections[key] = append(connections[:index], connections[index+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Discovery that fails to register or deregister connections
+type failingDiscovery struct {
+	*registryDiscovery
+	failRegister   bool
+	failDeregister bool
+}
+
+func (c *failingDiscovery) Register(correlationId string, key string,
+	connection *cconnect.ConnectionParams) (*cconnect.ConnectionParams, error) {
+	if c.failRegister {
+		return nil, cerr.NewInvocationError(correlationId, "REGISTER_FAILED", "Failed to register")
+	}
+	return c.registryDiscovery.Register(correlationId, key, connection)
+}
+
+func (c *failingDiscovery) Deregister(correlationId string, key string, connection *cconnect.ConnectionParams) error {
+	if c.failDeregister {
+		return cerr.NewInvocationError(correlationId, "DEREGISTER_FAILED", "Failed to deregister")
+	}
+	return c.registryDiscovery.Deregister(correlationId, key, connection)
+}
+
+func newRegistrationServer(port string, discoveries ...interface{}) *pservice.PrometheusMetricsServer {
+	server := pservice.NewPrometheusMetricsServer()
+	server.Configure(cconf.NewConfigParamsFromTuples(
+		"connection.protocol", "http",
+		"connection.host", "localhost",
+		"connection.port", port,
+		"registration.discovery_key", "metrics",
+	))
+	references := make([]interface{}, 0)
+	for index, discovery := range discoveries {
+		references = append(references,
+			cref.NewDescriptor("pip-services", "discovery", "memory", strconv.Itoa(index), "1.0"), discovery)
+	}
+	server.SetReferences(cref.NewReferencesFromTuples(references...))
+	return server
+}
+
+func TestPrometheusMetricsServiceRegistration(t *testing.T) {
+	discovery := newRegistryDiscovery()
+	contextInfo := cinfo.NewContextInfo()
+	contextInfo.Name = "Test"
+	contextInfo.ContextId = "host1"
+
+	service := pservice.NewPrometheusMetricsService()
+	service.Configure(cconf.NewConfigParamsFromTuples(
+		"base_route", "internal",
+		"connection.protocol", "http",
+		"connection.host", "0.0.0.0",
+		"connection.port", "3024",
+		"registration.discovery_key", "metrics",
+		"registration.labels.team", "core",
+	))
+	service.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "context-info", "default", "default", "1.0"), contextInfo,
+		cref.NewDescriptor("pip-services", "discovery", "memory", "default", "1.0"), discovery,
+	))
+
+	err := service.Open("")
+	assert.Nil(t, err)
+	waitForEndpoint(t, "3024")
+
+	host, _ := os.Hostname()
+	connections, _ := discovery.ResolveAll("", "metrics")
+	if assert.Len(t, connections, 1) {
+		connection := connections[0]
+		assert.Equal(t, host, connection.Host())
+		assert.Equal(t, 3024, connection.Port())
+		assert.Equal(t, "http://"+host+":3024/internal/metrics", connection.Uri())
+		assert.Equal(t, "Test", connection.GetAsString("labels.source"))
+		assert.Equal(t, "host1", connection.GetAsString("labels.instance"))
+		assert.Equal(t, "http", connection.GetAsString("labels.scheme"))
+		assert.Equal(t, "/internal/metrics", connection.GetAsString("labels.path"))
+		assert.Equal(t, "core", connection.GetAsString("labels.team"))
+	}
+
+	err = service.Close("")
+	assert.Nil(t, err)
+
+	connections, _ = discovery.ResolveAll("", "metrics")
+	assert.Len(t, connections, 0)
+}
+
+func TestPrometheusMetricsServiceRegistrationSharedEndpoint(t *testing.T) {
+	discovery := newRegistryDiscovery()
+	endpoint := rpcservices.NewHttpEndpoint()
+	endpoint.Configure(newServiceConfig("3031"))
+
+	service := pservice.NewPrometheusMetricsService()
+	service.Configure(cconf.NewConfigParamsFromTuples(
+		"registration.discovery_key", "metrics",
+	))
+	service.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "endpoint", "http", "default", "1.0"), endpoint,
+		cref.NewDescriptor("pip-services", "discovery", "memory", "default", "1.0"), discovery,
+	))
+
+	err := endpoint.Open("")
+	assert.Nil(t, err)
+	defer endpoint.Close("")
+	waitForEndpoint(t, "3031")
+
+	// The port of the shared endpoint is registered
+	err = service.Open("")
+	assert.Nil(t, err)
+
+	connections, _ := discovery.ResolveAll("", "metrics")
+	if assert.Len(t, connections, 1) {
+		assert.Equal(t, "http://localhost:3031/metrics", connections[0].Uri())
+	}
+
+	err = service.Close("")
+	assert.Nil(t, err)
+}
+
+func TestPrometheusMetricsServiceRegistrationMemoryDiscovery(t *testing.T) {
+	discovery := cconnect.NewEmptyMemoryDiscovery()
+
+	server := newRegistrationServer("3028", discovery)
+	err := server.Open("")
+	assert.Nil(t, err)
+
+	connections, _ := discovery.ResolveAll("", "metrics")
+	assert.Len(t, connections, 1)
+
+	// MemoryDiscovery can't remove connections, so close only logs a warning
+	err = server.Close("")
+	assert.Nil(t, err)
+}
+
+func TestPrometheusMetricsServerRegistration(t *testing.T) {
+	discovery := newRegistryDiscovery()
+
+	server := pservice.NewPrometheusMetricsServer()
+	server.Configure(cconf.NewConfigParamsFromTuples(
+		"connection.protocol", "http",
+		"connection.host", "localhost",
+		"connection.port", "3025",
+		"registration.discovery_key", "metrics",
+		"registration.host", "metrics.example.com",
+	))
+	server.SetReferences(cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "discovery", "memory", "default", "1.0"), discovery,
+	))
+
+	err := server.Open("")
+	assert.Nil(t, err)
+
+	connections, _ := discovery.ResolveAll("", "metrics")
+	if assert.Len(t, connections, 1) {
+		assert.Equal(t, "http://metrics.example.com:3025/metrics", connections[0].Uri())
+	}
+
+	err = server.Close("")
+	assert.Nil(t, err)
+
+	connections, _ = discovery.ResolveAll("", "metrics")
+	assert.Len(t, connections, 0)
+}
+
+func TestPrometheusMetricsServerRegistrationRollback(t *testing.T) {
+	discovery1 := newRegistryDiscovery()
+	discovery2 := &failingDiscovery{registryDiscovery: newRegistryDiscovery(), failRegister: true}
+
+	server := newRegistrationServer("3028", discovery1, discovery2)
+	err := server.Open("")
+	assert.NotNil(t, err)
+	assert.False(t, server.IsOpen())
+
+	// Registration that succeeded is rolled back
+	connections, _ := discovery1.ResolveAll("", "metrics")
+	assert.Len(t, connections, 0)
+}
+
+func TestPrometheusMetricsServerDeregistrationErrors(t *testing.T) {
+	discovery1 := &failingDiscovery{registryDiscovery: newRegistryDiscovery()}
+	discovery2 := &failingDiscovery{registryDiscovery: newRegistryDiscovery()}
+	discovery3 := newRegistryDiscovery()
+
+	server := newRegistrationServer("3028", discovery1, discovery2, discovery3)
+	err := server.Open("")
+	assert.Nil(t, err)
+
+	discovery1.failDeregister = true
+	discovery2.failDeregister = true
+	err = server.Close("")
+	assert.Nil(t, err)
+
+	// Failed services don't stop deregistration from the others
+	connections, _ := discovery1.ResolveAll("", "metrics")
+	assert.Len(t, connections, 1)
+	connections, _ = discovery3.ResolveAll("", "metrics")
+	assert.Len(t, connections, 0)
+}
@@ -0,0 +1,51 @@
+package test_services
+
+import (
+	"runtime"
+	"testing"
+
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetricsServiceRuntimeMetrics(t *testing.T) {
+	service, counters := openMetricsService(t, "3012",
+		"options.runtime_metrics", true,
+	)
+	defer service.Close("")
+	defer counters.Close("")
+
+	res, body := scrape(t, "http://localhost:3012/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "# TYPE go_goroutines gauge\n")
+	assert.Contains(t, body, "# TYPE go_memstats_heap_alloc_bytes gauge\n")
+	assert.Contains(t, body, "# TYPE go_gc_duration_seconds summary\n")
+}
+
+func TestPrometheusMetricsServiceRuntimeCollectorReference(t *testing.T) {
+	service, counters := openMetricsServiceWithReferences(t, "3013", []interface{}{
+		cref.NewDescriptor("pip-services", "metrics-collector", "runtime", "default", "1.0"), pcount.NewGoRuntimeCollector(),
+	}, "options.runtime_metrics", true)
+	defer service.Close("")
+	defer counters.Close("")
+
+	res, body := scrape(t, "http://localhost:3013/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, 1, countOccurrences(body, "# TYPE go_goroutines gauge\n"))
+}
+
+func TestPrometheusMetricsServiceProcessMetrics(t *testing.T) {
+	service, counters := openMetricsService(t, "3014",
+		"options.process_metrics", true,
+	)
+	defer service.Close("")
+	defer counters.Close("")
+
+	res, body := scrape(t, "http://localhost:3014/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	if runtime.GOOS == "linux" {
+		assert.Contains(t, body, "# TYPE process_cpu_seconds_total counter\n")
+		assert.Contains(t, body, "# TYPE process_open_fds gauge\n")
+	}
+}
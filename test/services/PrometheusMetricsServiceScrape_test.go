@@ -0,0 +1,168 @@
+package test_services
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	cinfo "github.com/pip-services3-go/pip-services3-components-go/info"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Creates metrics service configuration for the given port with additional parameters
+func newServiceConfig(port string, tuples ...interface{}) *cconf.ConfigParams {
+	config := cconf.NewConfigParamsFromTuples(
+		"connection.protocol", "http",
+		"connection.host", "localhost",
+		"connection.port", port,
+	)
+	return cconf.NewConfigParamsFromTuples(tuples...).SetDefaults(config)
+}
+
+// Opens metrics service with PrometheusCounters on the given port
+func openMetricsService(t *testing.T, port string, tuples ...interface{}) (*pservice.PrometheusMetricsService, *pcount.PrometheusCounters) {
+	return openMetricsServiceWithReferences(t, port, nil, tuples...)
+}
+
+// Opens metrics service with PrometheusCounters and additional references on the given port
+func openMetricsServiceWithReferences(t *testing.T, port string, referenceTuples []interface{},
+	tuples ...interface{}) (*pservice.PrometheusMetricsService, *pcount.PrometheusCounters) {
+	service := pservice.NewPrometheusMetricsService()
+	service.Configure(newServiceConfig(port, tuples...))
+
+	counters := pcount.NewPrometheusCounters()
+
+	contextInfo := cinfo.NewContextInfo()
+	contextInfo.Name = "Test"
+
+	references := cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "context-info", "default", "default", "1.0"), contextInfo,
+		cref.NewDescriptor("pip-services", "counters", "prometheus", "default", "1.0"), counters,
+		cref.NewDescriptor("pip-services", "metrics-service", "prometheus", "default", "1.0"), service,
+	)
+	for i := 0; i+1 < len(referenceTuples); i += 2 {
+		references.Put(referenceTuples[i], referenceTuples[i+1])
+	}
+	counters.SetReferences(references)
+	service.SetReferences(references)
+
+	err := counters.Open("")
+	assert.Nil(t, err)
+	err = service.Open("")
+	assert.Nil(t, err)
+	waitForEndpoint(t, port)
+
+	return service, counters
+}
+
+// Waits until the endpoint started in background accepts connections on the given port
+func waitForEndpoint(t *testing.T, port string) {
+	var err error
+	for i := 0; i < 100; i++ {
+		var conn net.Conn
+		conn, err = net.Dial("tcp", "localhost:"+port)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err)
+}
+
+func scrape(t *testing.T, url string, headers ...string) (*http.Response, string) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	// Transport with disabled compression returns encoded body as is
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	var reader io.Reader = res.Body
+	switch res.Header.Get("Content-Encoding") {
+	case "gzip":
+		reader, err = gzip.NewReader(res.Body)
+		assert.Nil(t, err)
+	case "deflate":
+		reader = flate.NewReader(res.Body)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	return res, string(body)
+}
+
+func countOccurrences(text string, substring string) int {
+	return strings.Count(text, substring)
+}
+
+func TestPrometheusMetricsServiceRoute(t *testing.T) {
+	service, counters := openMetricsService(t, "3001", "route", "custom/metrics")
+	defer service.Close("")
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+
+	res, body := scrape(t, "http://localhost:3001/custom/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "test_counter1")
+
+	res, _ = scrape(t, "http://localhost:3001/metrics")
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestPrometheusMetricsServiceCompression(t *testing.T) {
+	service, counters := openMetricsService(t, "3002")
+	defer service.Close("")
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+
+	res, body := scrape(t, "http://localhost:3002/metrics", "Accept-Encoding", "gzip")
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Contains(t, body, "test_counter1")
+
+	res, body = scrape(t, "http://localhost:3002/metrics", "Accept-Encoding", "gzip;q=0.5, deflate")
+	assert.Equal(t, "deflate", res.Header.Get("Content-Encoding"))
+	assert.Contains(t, body, "test_counter1")
+
+	res, body = scrape(t, "http://localhost:3002/metrics", "Accept-Encoding", "identity, gzip;q=0")
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+	assert.Contains(t, body, "test_counter1")
+}
+
+func TestPrometheusMetricsServiceWithoutCompression(t *testing.T) {
+	service, counters := openMetricsService(t, "3003", "options.compression", false)
+	defer service.Close("")
+	defer counters.Close("")
+
+	res, _ := scrape(t, "http://localhost:3003/metrics", "Accept-Encoding", "gzip")
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+}
+
+func TestPrometheusMetricsServiceScrapeTimeout(t *testing.T) {
+	service, counters := openMetricsService(t, "3004")
+	defer service.Close("")
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+
+	res, body := scrape(t, "http://localhost:3004/metrics", "X-Prometheus-Scrape-Timeout-Seconds", "5")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "test_counter1")
+}
@@ -0,0 +1,106 @@
+package test_services
+
+import (
+	"testing"
+
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	ccount "github.com/pip-services3-go/pip-services3-components-go/count"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetricsServiceSeveralSources(t *testing.T) {
+	backup := pcount.NewPrometheusCounters()
+
+	service, counters := openMetricsServiceWithReferences(t, "3017", []interface{}{
+		cref.NewDescriptor("pip-services", "counters", "prometheus", "backup", "1.0"), backup,
+		cref.NewDescriptor("pip-services", "counters", "composite", "default", "1.0"), ccount.NewCompositeCounters(),
+		cref.NewDescriptor("pip-services", "counters", "unknown", "default", "1.0"), "not counters",
+	})
+	defer service.Close("")
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+	backup.IncrementOne("test.counter1")
+	backup.Increment("test.counter1", 2)
+	backup.Registry().AddCounter("jobs_total", "Total number of jobs", nil, 5)
+
+	res, body := scrape(t, "http://localhost:3017/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, 1, countOccurrences(body, "# TYPE test_counter1 gauge\n"))
+	assert.Contains(t, body, `test_counter1{counters="prometheus",instance="`)
+	assert.Contains(t, body, `test_counter1{counters="prometheus:backup",instance="`)
+	assert.Contains(t, body, `source="Test"} 3`)
+	assert.Contains(t, body, `jobs_total{counters="prometheus:backup",instance="`)
+	assert.NotContains(t, body, "composite")
+}
+
+func TestPrometheusMetricsServiceSingleSourceWithoutLabel(t *testing.T) {
+	service, counters := openMetricsService(t, "3018")
+	defer service.Close("")
+	defer counters.Close("")
+
+	counters.IncrementOne("test.counter1")
+
+	res, body := scrape(t, "http://localhost:3018/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "test_counter1{")
+	assert.NotContains(t, body, "counters=")
+}
+
+func TestPrometheusMetricsServiceWithoutReferences(t *testing.T) {
+	service := pservice.NewPrometheusMetricsService()
+	service.Configure(newServiceConfig("3019"))
+
+	assert.NotPanics(t, func() {
+		service.SetReferences(cref.NewReferencesFromTuples(
+			cref.NewDescriptor("pip-services", "counters", "prometheus", "default", "1.0"), "not counters",
+			cref.NewDescriptor("pip-services", "context-info", "default", "default", "1.0"), 123,
+		))
+	})
+
+	err := service.Open("")
+	assert.Nil(t, err)
+	defer service.Close("")
+	waitForEndpoint(t, "3019")
+
+	res, _ := scrape(t, "http://localhost:3019/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+// References that return components in a different order than their locators
+type reorderedReferences struct {
+	*cref.References
+}
+
+func (c *reorderedReferences) GetAll() []interface{} {
+	components := c.References.GetAll()
+	for i, j := 0, len(components)-1; i < j; i, j = i+1, j-1 {
+		components[i], components[j] = components[j], components[i]
+	}
+	return components
+}
+
+func TestPrometheusMetricsServiceCachedCountersOnly(t *testing.T) {
+	counters := ccount.NewLogCounters()
+	references := cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "context-info", "default", "default", "1.0"), "not context info",
+		cref.NewDescriptor("pip-services", "counters", "log", "default", "1.0"), counters,
+	)
+
+	service := pservice.NewPrometheusMetricsService()
+	service.Configure(newServiceConfig("3030"))
+	service.SetReferences(&reorderedReferences{references})
+
+	err := service.Open("")
+	assert.Nil(t, err)
+	defer service.Close("")
+	waitForEndpoint(t, "3030")
+
+	counters.IncrementOne("test.counter1")
+
+	res, body := scrape(t, "http://localhost:3030/metrics")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Contains(t, body, "test_counter1 1\n")
+}
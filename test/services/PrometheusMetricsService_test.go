@@ -47,6 +47,7 @@ func TestPrometheusMetricsService(t *testing.T) {
 
 	defer service.Close("")
 	defer counters.Close("")
+	waitForEndpoint(t, "3000")
 
 	var url = "http://localhost:3000"
 
@@ -62,3 +63,17 @@ func TestPrometheusMetricsService(t *testing.T) {
 	body, _ := ioutil.ReadAll(getRes.Body)
 	assert.True(t, len(body) > 0)
 }
+
+func TestPrometheusMetricsServiceRegistryMetrics(t *testing.T) {
+	service, counters := openMetricsService(t, "3029")
+	defer service.Close("")
+	defer counters.Close("")
+
+	instance := cinfo.NewContextInfo().ContextId
+	counters.IncrementOne("test.counter1")
+	counters.Registry().AddCounter("test_requests_total", "", map[string]string{"code": "200"}, 1)
+
+	_, body := scrape(t, "http://localhost:3029/metrics")
+	assert.Contains(t, body, "test_counter1{instance=\""+instance+"\",source=\"Test\"} 1")
+	assert.Contains(t, body, "test_requests_total{code=\"200\",instance=\""+instance+"\",source=\"Test\"} 1")
+}
@@ -0,0 +1,242 @@
+package test_services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cconf "github.com/pip-services3-go/pip-services3-commons-go/config"
+	cref "github.com/pip-services3-go/pip-services3-commons-go/refer"
+	pcount "github.com/pip-services3-go/pip-services3-prometheus-go/count"
+	pservice "github.com/pip-services3-go/pip-services3-prometheus-go/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPushGatewayService(t *testing.T, path string, tuples ...interface{}) *pservice.PrometheusPushGatewayService {
+	gateway := pservice.NewPrometheusPushGatewayService()
+	gateway.Configure(cconf.NewConfigParamsFromTuples(append([]interface{}{
+		"connection.protocol", "http",
+		"connection.host", "localhost",
+		"connection.port", "3027",
+		"persistence.path", path,
+	}, tuples...)...))
+	err := gateway.Open("")
+	assert.Nil(t, err)
+	waitForEndpoint(t, "3027")
+	return gateway
+}
+
+func pushMetrics(t *testing.T, method string, path string, body string) int {
+	req, _ := http.NewRequest(method, "http://localhost:3027"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	// Connections are not reused, since the gateway is reopened
+	req.Close = true
+	res, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err) {
+		return 0
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func collectGroups(gateway *pservice.PrometheusPushGatewayService) map[string]*pcount.PrometheusMetricFamily {
+	result := make(map[string]*pcount.PrometheusMetricFamily)
+	for _, family := range pcount.CollectMetricFamilies([]pcount.ICollector{gateway}) {
+		result[family.Name] = family
+	}
+	return result
+}
+
+func TestPrometheusPushGatewayService(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pushgateway")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.json")
+
+	gateway := newPushGatewayService(t, path)
+
+	// Prometheus counters push compressed metrics like to a real Pushgateway
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"source", "batch",
+		"instance", "host1",
+		"connection.protocol", "http",
+		"connection.host", "localhost",
+		"connection.port", "3027",
+		"push.compression", "gzip",
+		"push.compression_min_size", 0,
+	))
+	err := counters.Open("")
+	assert.Nil(t, err)
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+	counters.Close("")
+
+	families := collectGroups(gateway)
+	family := families["test_counter1"]
+	if assert.NotNil(t, family) && assert.Len(t, family.Samples, 1) {
+		assert.Equal(t, "batch", family.Samples[0].Labels["job"])
+		assert.Equal(t, "host1", family.Samples[0].Labels["instance"])
+		assert.Equal(t, 1.0, family.Samples[0].Value)
+	}
+	if assert.NotNil(t, families["push_time_seconds"]) {
+		assert.True(t, families["push_time_seconds"].Samples[0].Value > 0)
+	}
+
+	// POST replaces only metrics with the same names, base64 values may contain slashes
+	status := pushMetrics(t, http.MethodPut, "/metrics/job/nightly/path@base64/L3Zhci90bXA",
+		"# TYPE job_rows gauge\njob_rows 10\n# TYPE job_errors gauge\njob_errors 1\n")
+	assert.Equal(t, http.StatusOK, status)
+	status = pushMetrics(t, http.MethodPost, "/metrics/job/nightly/path@base64/L3Zhci90bXA",
+		"# TYPE job_rows gauge\njob_rows 20\n")
+	assert.Equal(t, http.StatusOK, status)
+
+	families = collectGroups(gateway)
+	if assert.NotNil(t, families["job_rows"]) {
+		assert.Equal(t, 20.0, families["job_rows"].Samples[0].Value)
+		assert.Equal(t, "/var/tmp", families["job_rows"].Samples[0].Labels["path"])
+	}
+	assert.NotNil(t, families["job_errors"])
+
+	// Inconsistent metrics are rejected and recorded as failures
+	status = pushMetrics(t, http.MethodPut, "/metrics/job/nightly/path@base64/L3Zhci90bXA",
+		"job_rows{job=\"other\"} 1\n")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status = pushMetrics(t, http.MethodPut, "/metrics/job/nightly/path@base64/L3Zhci90bXA", "job_rows 1 1600000000000\n")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status = pushMetrics(t, http.MethodPut, "/metrics/instance/host1", "job_rows 1\n")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost:3027/api/v1/metrics", nil)
+	req.Close = true
+	res, err := http.DefaultClient.Do(req)
+	if assert.Nil(t, err) {
+		var result struct {
+			Status string                   `json:"status"`
+			Data   []map[string]interface{} `json:"data"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, "success", result.Status)
+		if assert.Len(t, result.Data, 2) {
+			nightly := result.Data[1]
+			assert.Equal(t, false, nightly["last_push_successful"])
+			rows := nightly["job_rows"].(map[string]interface{})
+			assert.Equal(t, "GAUGE", rows["type"])
+			metrics := rows["metrics"].([]interface{})
+			assert.Equal(t, "20", metrics[0].(map[string]interface{})["value"])
+		}
+	}
+
+	// Groups are persisted on close and loaded on open
+	err = gateway.Close("")
+	assert.Nil(t, err)
+	gateway = newPushGatewayService(t, path)
+
+	families = collectGroups(gateway)
+	assert.NotNil(t, families["test_counter1"])
+	assert.NotNil(t, families["job_rows"])
+
+	status = pushMetrics(t, http.MethodDelete, "/metrics/job/batch/instance/host1", "")
+	assert.Equal(t, http.StatusAccepted, status)
+	families = collectGroups(gateway)
+	assert.Nil(t, families["test_counter1"])
+	assert.NotNil(t, families["job_rows"])
+
+	err = gateway.Close("")
+	assert.Nil(t, err)
+}
+
+func TestPrometheusPushGatewayServiceMaxBodySize(t *testing.T) {
+	gateway := newPushGatewayService(t, "", "options.max_body_size", 100)
+	defer gateway.Close("")
+
+	status := pushMetrics(t, http.MethodPut, "/metrics/job/batch", "small_metric 1\n")
+	assert.Equal(t, http.StatusOK, status)
+
+	large := strings.Repeat("large_metric 1\n", 10)
+	status = pushMetrics(t, http.MethodPut, "/metrics/job/batch", large)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	// Compressed body is small, but it is limited after decompression as well
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	writer.Write([]byte(large))
+	writer.Close()
+	assert.Less(t, buffer.Len(), 100)
+
+	req, _ := http.NewRequest(http.MethodPut, "http://localhost:3027/metrics/job/batch", &buffer)
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Close = true
+	res, err := http.DefaultClient.Do(req)
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	}
+
+	families := collectGroups(gateway)
+	assert.NotNil(t, families["small_metric"])
+	assert.Nil(t, families["large_metric"])
+}
+
+func TestPrometheusPushGatewayServiceWithCounters(t *testing.T) {
+	gateway := newPushGatewayService(t, "")
+	defer gateway.Close("")
+
+	status := pushMetrics(t, http.MethodPut, "/metrics/job/batch/instance/edge1", "edge_temperature 21\n")
+	assert.Equal(t, http.StatusOK, status)
+
+	pushed := make(chan string, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		pushed <- string(body)
+	}))
+	defer upstream.Close()
+	address, _ := url.Parse(upstream.URL)
+
+	counters := pcount.NewPrometheusCounters()
+	counters.Configure(cconf.NewConfigParamsFromTuples(
+		"source", "app",
+		"instance", "host1",
+		"connection.uri", "http://"+address.Host,
+	))
+	references := cref.NewReferencesFromTuples(
+		cref.NewDescriptor("pip-services", "counters", "prometheus", "default", "1.0"), counters,
+		cref.NewDescriptor("pip-services", "metrics-relay", "pushgateway", "default", "1.0"), gateway,
+	)
+	counters.SetReferences(references)
+	err := counters.Open("")
+	assert.Nil(t, err)
+	defer counters.Close("")
+
+	// Pushed groups are not pushed upstream again
+	counters.IncrementOne("test.counter1")
+	err = counters.Save(counters.GetAll())
+	assert.Nil(t, err)
+	body := <-pushed
+	assert.Contains(t, body, "test_counter1 1\n")
+	assert.NotContains(t, body, "edge_temperature")
+	assert.NotContains(t, body, "push_time_seconds")
+
+	// Metrics handler exposes both counters and pushed groups
+	handler := pservice.NewPrometheusMetricsHandler()
+	handler.SetReferences(references)
+	handler.SetLabels("app", "host1")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, body = scrape(t, server.URL)
+	assert.Contains(t, body, `test_counter1{instance="host1",source="app"} 1`)
+	assert.Contains(t, body, `edge_temperature{exported_instance="edge1",instance="host1",job="batch",source="app"} 21`)
+}